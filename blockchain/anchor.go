@@ -0,0 +1,220 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+var ErrAnchorNotFound = errors.New("data not anchored on chain")
+
+// NewDataTransaction creates a signed transaction anchoring data in a null
+// data output. The wallet pays fee, the rest of the selected inputs goes
+// back to it as change.
+func NewDataTransaction(w *wallet.Wallet, data []byte, fee int, UTXO *UTXOSet) *Transaction {
+	if len(data) > MaxDataCarrierSize {
+		log.Panicf("Error: data has %d bytes, max %d", len(data), MaxDataCarrierSize)
+	}
+	if fee < 0 {
+		log.Panic("Error: fee can't be negative")
+	}
+
+	// A transaction needs an input even if it pays no fee.
+	needed := fee
+	if needed == 0 {
+		needed = 1
+	}
+
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKeyBytes())
+	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, needed)
+
+	if acc < needed {
+		log.Panic("Error: not enough funds")
+	}
+
+	var inputs []TxInput
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		Handle(err)
+
+		for _, out := range outs {
+			inputs = append(inputs, TxInput{ID: txID, Out: out})
+		}
+	}
+
+	outputs := []TxOutput{*NewDataOutput(data)}
+	if acc > fee {
+		outputs = append(outputs, *NewTXOutput(acc-fee, string(w.Address())))
+	}
+
+	tx := Transaction{
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
+	}
+	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
+
+	return &tx
+}
+
+// anchorIndexPrefix keys the transaction index entries of anchored data by
+// the sha256 of the data. An entry points at the transaction that first
+// anchored the data on the active chain.
+var anchorIndexPrefix = []byte("anchor-")
+
+func anchorIndexKey(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return append(append([]byte{}, anchorIndexPrefix...), hash[:]...)
+}
+
+// indexBlockAnchors records the data anchored by block that isn't anchored
+// by an earlier block. Blocks are connected from the fork point up, so the
+// first entry is the earliest one.
+func indexBlockAnchors(txn *badger.Txn, block *Block) error {
+	for pos, tx := range block.Transactions {
+		for _, out := range tx.Outputs {
+			data, ok := ExtractNullData(out.LockingScript())
+			if !ok {
+				continue
+			}
+
+			key := anchorIndexKey(data)
+			if _, err := txn.Get(key); err == nil {
+				continue
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			loc := TxLocation{BlockHash: block.Hash, Position: pos}
+			if err := txn.Set(key, loc.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexBlockAnchors removes the entries pointing at block. Blocks are
+// disconnected from the tip down, so no later block anchoring the same
+// data is left on the active chain.
+func unindexBlockAnchors(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		for _, out := range tx.Outputs {
+			data, ok := ExtractNullData(out.LockingScript())
+			if !ok {
+				continue
+			}
+
+			key := anchorIndexKey(data)
+
+			item, err := txn.Get(key)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(DeserializeTxLocation(val).BlockHash, block.Hash) {
+				continue
+			}
+
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LookupAnchor finds the active chain transaction that first anchored data
+// and its block. It uses the transaction index when it is enabled; otherwise
+// it walks the active chain from genesis up to the first match, which takes
+// longer the later the data was anchored.
+func (chain *Blockchain) LookupAnchor(data []byte) (*TxLookup, error) {
+	var lookup *TxLookup
+	var err error
+
+	if chain.txIndex {
+		lookup, err = chain.lookupIndexedAnchor(data)
+	} else {
+		lookup, err = chain.scanAnchor(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lookup.Confirmations = chain.GetBestHeight() - lookup.Block.Height + 1
+
+	return lookup, nil
+}
+
+func (chain *Blockchain) lookupIndexedAnchor(data []byte) (*TxLookup, error) {
+	var loc TxLocation
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(anchorIndexKey(data))
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		loc = DeserializeTxLocation(val)
+
+		return nil
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrAnchorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("indexed block %x not found: %w", loc.BlockHash, err)
+	}
+
+	return &TxLookup{Transaction: block.Transactions[loc.Position], Block: &block, Position: loc.Position}, nil
+}
+
+func (chain *Blockchain) scanAnchor(data []byte) (*TxLookup, error) {
+	tip := chain.GetBestHeight()
+
+	for height := 0; height <= tip; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+
+		for pos, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				carried, ok := ExtractNullData(out.LockingScript())
+				if ok && bytes.Equal(carried, data) {
+					return &TxLookup{Transaction: tx, Block: block, Position: pos}, nil
+				}
+			}
+		}
+	}
+
+	return nil, ErrAnchorNotFound
+}
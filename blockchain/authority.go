@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// AuthoritySealSize is the length of a proof of authority seal: the public
+// key of the validator followed by its signature of the block hash, which
+// commits to the key.
+const AuthoritySealSize = ed25519.PublicKeySize + ed25519.SignatureSize
+
+var (
+	ErrNotValidator    = errors.New("signer is not a validator")
+	ErrSignedRecently  = errors.New("validator signed one of the last blocks")
+	errMalformedSeal   = errors.New("seal isnt a validator key and signature")
+	errInvalidSealSign = errors.New("seal signature doesnt match block hash")
+)
+
+// ProofOfAuthority accepts blocks signed by one of Validators. Validators
+// take turns by height; a block signed by the validator whose turn it is
+// weighs 2 in fork choice, other blocks weigh 1, so that a validator that
+// is offline doesn't stop the chain but the rightful block wins. A
+// validator can sign only one of any len(Validators)/2+1 consecutive
+// blocks, except a single validator, which signs every block. The genesis
+// block isn't signed.
+//
+// Signer is the key this node seals blocks with.
+type ProofOfAuthority struct {
+	Validators [][]byte
+	Signer     ed25519.PrivateKey
+}
+
+func (c *ProofOfAuthority) Name() string {
+	return ProofOfAuthorityEngine
+}
+
+// Prepare clears the proof of work fields, which authority blocks don't
+// use.
+func (c *ProofOfAuthority) Prepare(chain *Blockchain, block, parent *Block) error {
+	block.Bits = 0
+	block.Nonce = 0
+
+	return nil
+}
+
+// Seal signs block with Signer.
+func (c *ProofOfAuthority) Seal(ctx context.Context, chain *Blockchain, block *Block) error {
+	if block.Height == 0 {
+		block.Seal = nil
+		block.Hash = block.Header().Hash()
+		return nil
+	}
+
+	if c.Signer == nil {
+		return ErrNotValidator
+	}
+
+	pubKey := c.Signer.Public().(ed25519.PublicKey)
+	if c.validatorIndex(pubKey) < 0 {
+		return ErrNotValidator
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return err
+	}
+
+	recent, err := c.signedRecently(chain, pubKey, &parent)
+	if err != nil {
+		return err
+	}
+	if recent {
+		return ErrSignedRecently
+	}
+
+	// The hash covers the key the seal starts with, so it is set before
+	// signing.
+	block.Seal = append([]byte{}, pubKey...)
+	block.Hash = block.Header().Hash()
+	block.Seal = append(block.Seal, ed25519.Sign(c.Signer, block.Hash)...)
+
+	chain.Logger.Infow("new_block_signed",
+		"block_hash", block.GetHash(),
+		"block_height", block.Height,
+		"in_turn", c.inTurn(pubKey, block.Height),
+	)
+
+	return nil
+}
+
+// VerifySeal checks that a validator that hasn't signed the last blocks
+// signed block.
+func (c *ProofOfAuthority) VerifySeal(chain *Blockchain, block, parent *Block) error {
+	if block.Bits != 0 || block.Nonce != 0 {
+		return ruleError(RejectBadBits, block.GetHash(), "proof of authority blocks have no target and nonce")
+	}
+
+	if parent == nil {
+		return nil
+	}
+
+	signer, err := AuthoritySigner(block.Hash, block.Seal)
+	if err != nil {
+		chain.Logger.Warnw("block_seal_invalid",
+			"hash", block.GetHash(),
+			"error", err,
+		)
+		return ruleError(RejectBadSeal, block.GetHash(), "%s", err)
+	}
+
+	if c.validatorIndex(signer) < 0 {
+		chain.Logger.Warnw("block_signer_not_validator",
+			"hash", block.GetHash(),
+			"signer", fmt.Sprintf("%x", signer),
+		)
+		return ruleError(RejectBadSeal, block.GetHash(), "signer %x is not a validator", signer)
+	}
+
+	recent, err := c.signedRecently(chain, signer, parent)
+	if err != nil {
+		return err
+	}
+	if recent {
+		return ruleError(RejectBadSeal, block.GetHash(), "signer %x signed one of the last %d blocks", signer, c.recentLimit())
+	}
+
+	return nil
+}
+
+// Weight is 2 for blocks signed in turn and 1 otherwise.
+func (c *ProofOfAuthority) Weight(block *Block) *big.Int {
+	signer, err := AuthoritySigner(block.Hash, block.Seal)
+	if err == nil && c.inTurn(signer, block.Height) {
+		return big.NewInt(2)
+	}
+
+	return big.NewInt(1)
+}
+
+func (c *ProofOfAuthority) validatorIndex(pubKey []byte) int {
+	for i, validator := range c.Validators {
+		if bytes.Equal(validator, pubKey) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *ProofOfAuthority) inTurn(pubKey []byte, height int) bool {
+	return bytes.Equal(c.Validators[height%len(c.Validators)], pubKey)
+}
+
+// recentLimit is how many blocks before a new one its signer may not have
+// signed. A single validator has to sign every block, so it has no limit.
+func (c *ProofOfAuthority) recentLimit() int {
+	if len(c.Validators) == 1 {
+		return 0
+	}
+
+	return len(c.Validators) / 2
+}
+
+// signedRecently reports whether pubKey signed one of the last recentLimit
+// blocks up to parent.
+func (c *ProofOfAuthority) signedRecently(chain *Blockchain, pubKey []byte, parent *Block) (bool, error) {
+	block := parent
+
+	for i := 0; i < c.recentLimit() && block.Height > 0; i++ {
+		if signer, err := AuthoritySigner(block.Hash, block.Seal); err == nil && bytes.Equal(signer, pubKey) {
+			return true, nil
+		}
+
+		prev, err := chain.GetBlock(block.PrevHash)
+		if err != nil {
+			return false, err
+		}
+		block = &prev
+	}
+
+	return false, nil
+}
+
+// AuthoritySigner returns the validator key of a proof of authority seal
+// once it checked that the key signed hash. It doesn't tell whether the
+// key belongs to a validator of the chain.
+func AuthoritySigner(hash, seal []byte) (ed25519.PublicKey, error) {
+	if len(seal) != AuthoritySealSize {
+		return nil, errMalformedSeal
+	}
+
+	pubKey := ed25519.PublicKey(seal[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pubKey, hash, seal[ed25519.PublicKeySize:]) {
+		return nil, errInvalidSealSign
+	}
+
+	return pubKey, nil
+}
@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/json"
@@ -17,6 +18,13 @@ type Block struct {
 	PrevHash     []byte
 	Nonce        int
 	Height       int
+	Bits         uint32
+	Version      int32
+	MerkleRoot   []byte
+	// Seal proves a proof of authority block was signed by a validator.
+	// The hash commits to the validator key the seal starts with, see
+	// BlockHeader.Signer, but not to the signature.
+	Seal []byte
 }
 
 // DEBUG
@@ -44,20 +52,21 @@ func (b *Block) TxInfo() map[string]string {
 	return info
 }
 
+// HashTransactions returns the merkle root of the transaction IDs.
 func (b *Block) HashTransactions() []byte {
 	var txHashes [][]byte
 
 	b.SortTxs()
 
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.Serialize())
+		txHashes = append(txHashes, tx.ID)
 	}
 
-	tree := NewMerkleTree(txHashes)
-
-	return tree.MerkleRoot()
+	return MerkleRoot(txHashes)
 }
 
+// JsonHashTransactions returns the merkle root of the JSON encoded
+// transactions that blocks before version 2 committed to.
 func (b *Block) JsonHashTransactions() []byte {
 	var txHashes [][]byte
 
@@ -69,64 +78,74 @@ func (b *Block) JsonHashTransactions() []byte {
 		txHashes = append(txHashes, hash[:])
 	}
 
-	tree := NewMerkleTree(txHashes)
-
-	return tree.MerkleRoot()
+	return MerkleRoot(txHashes)
 }
 
 func (b *Block) SortTxs() {
 	SortTxs(b.Transactions)
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte, height int) *Block {
+// newBlock returns a block that isn't prepared and sealed yet.
+func newBlock(txs []*Transaction, prevHash []byte, height int, timestamp int64) *Block {
 	block := &Block{
-		Timestamp:    time.Now().Unix(),
+		Timestamp:    timestamp,
 		Hash:         []byte{},
 		Transactions: txs,
 		PrevHash:     prevHash,
 		Nonce:        0,
 		Height:       height,
+		Version:      BlockVersion,
 	}
 
-	block.SortTxs()
-
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-
-	block.Nonce = nonce
-	block.Hash = hash
+	block.MerkleRoot = block.HashTransactions()
 
 	return block
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0)
+// genesis returns the first block of a new chain, paying coinbase and
+// sealed by the consensus engine of the chain.
+func (chain *Blockchain) genesis(coinbase *Transaction) *Block {
+	block := newBlock([]*Transaction{coinbase}, []byte{}, 0, time.Now().Unix())
+
+	err := chain.Consensus.Prepare(chain, block, nil)
+	Handle(err)
+
+	err = chain.Consensus.Seal(context.Background(), chain, block)
+	Handle(err)
+
+	return block
 }
 
 func (b *Block) GetHash() string {
 	return fmt.Sprintf("%x", b.Hash)
 }
 
+// Serialize returns the canonical encoding of the block, see
+// EncodingVersion.
 func (b *Block) Serialize() []byte {
-	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res)
-
-	err := encoder.Encode(b)
-	Handle(err)
-
-	return res.Bytes()
+	return EncodeBlock(b)
 }
 
+// Deserialize decodes a block. Blocks stored as gob by older builds are
+// still read, so that migrations written before the canonical encoding
+// keep working.
 func Deserialize(data []byte) *Block {
-	var block Block
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&block)
-	Handle(err)
+	var block *Block
+
+	if isLegacyEncoding(data) {
+		block = &Block{}
+		decoder := gob.NewDecoder(bytes.NewReader(data))
+		err := decoder.Decode(block)
+		Handle(err)
+	} else {
+		var err error
+		block, err = DecodeBlock(data)
+		Handle(err)
+	}
 
 	block.SortTxs()
 
-	return &block
+	return block
 }
 
 func Handle(err error) {
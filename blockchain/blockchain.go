@@ -2,30 +2,38 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
+	"github.com/aadejanovs/blockchain-demo/params"
 	"github.com/dgraph-io/badger"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-const (
-	dbPath      = "./tmp/blocks_%s"
-	genesisData = "Genesis data"
-)
+const dbPath = "blocks_%s"
 
 type Blockchain struct {
-	LastHash []byte
-	Database *badger.DB
-	Logger   *zap.SugaredLogger
+	LastHash   []byte
+	Database   *badger.DB
+	Logger     *zap.SugaredLogger
+	Params     *params.Params
+	Policy     MonetaryPolicy
+	Consensus  Consensus
+	TimeSource *MedianTimeSource
+
+	mu      sync.Mutex
+	txIndex bool
+	chainID []byte
 }
 
 func DBExists(path string) bool {
@@ -53,8 +61,36 @@ func SetupLogger(nodeId string) (*zap.SugaredLogger, error) {
 	return logger.Sugar(), nil
 }
 
+// DBPath returns the database directory of a node on the selected network.
+func DBPath(nodeId string) string {
+	return params.Active().DataPath(fmt.Sprintf(dbPath, nodeId))
+}
+
+// openDBPath returns the database directory of a node, after moving a
+// mainnet database older builds left in the old data directory there. The
+// legacy database is returned if it had to be left in place.
+func openDBPath(nodeId string) (path, legacyPath string) {
+	path = DBPath(nodeId)
+
+	legacyPath, err := params.Active().MigrateLegacyData(filepath.Base(path))
+	Handle(err)
+
+	return path, legacyPath
+}
+
+func warnLegacyData(logger *zap.SugaredLogger, legacyPath, path string) {
+	if legacyPath == "" {
+		return
+	}
+
+	logger.Warnw("legacy_data_left_in_place",
+		"legacy_path", legacyPath,
+		"path", path,
+	)
+}
+
 func ContinueBlockchain(nodeId string) *Blockchain {
-	path := fmt.Sprintf(dbPath, nodeId)
+	path, legacyPath := openDBPath(nodeId)
 	if !DBExists(path) {
 		fmt.Println("No existing blockchain found, create one!")
 		runtime.Goexit()
@@ -83,16 +119,47 @@ func ContinueBlockchain(nodeId string) *Blockchain {
 	logger, err := SetupLogger(nodeId)
 	Handle(err)
 
-	return &Blockchain{
-		LastHash: lastHash,
-		Database: db,
-		Logger:   logger,
+	warnLegacyData(logger, legacyPath, path)
+
+	chain := &Blockchain{
+		LastHash:   lastHash,
+		Database:   db,
+		Logger:     logger,
+		Params:     params.Active(),
+		TimeSource: NewMedianTimeSource(),
 	}
+
+	err = chain.checkNetwork()
+	Handle(err)
+
+	chain.Policy, err = chain.loadMonetaryPolicy()
+	Handle(err)
+
+	consensus, err := chain.loadConsensusConfig()
+	Handle(err)
+
+	chain.Consensus, err = NewConsensus(consensus)
+	Handle(err)
+
+	err = chain.migrate()
+	Handle(err)
+
+	chain.txIndex = chain.TxIndexEnabled()
+
+	chain.chainID, err = chain.GetBlockHashByHeight(0)
+	Handle(err)
+
+	return chain
 }
 
-func InitBlockchain(address, nodeId string) *Blockchain {
-	var lastHash []byte
-	path := fmt.Sprintf(dbPath, nodeId)
+func InitBlockchain(address, nodeId string, policy MonetaryPolicy, consensus ConsensusConfig) *Blockchain {
+	err := policy.Validate()
+	Handle(err)
+
+	engine, err := NewConsensus(consensus)
+	Handle(err)
+
+	path, legacyPath := openDBPath(nodeId)
 	if DBExists(path) {
 		fmt.Println("Blockchain already exists")
 		runtime.Goexit()
@@ -108,28 +175,53 @@ func InitBlockchain(address, nodeId string) *Blockchain {
 	logger, err := SetupLogger(nodeId)
 	Handle(err)
 
+	warnLegacyData(logger, legacyPath, path)
+
+	chain := &Blockchain{
+		Database:   db,
+		Logger:     logger,
+		Params:     params.Active(),
+		Policy:     policy,
+		Consensus:  engine,
+		TimeSource: NewMedianTimeSource(),
+	}
+
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
+		cbtx := CoinbaseTx(address, chain.Params.GenesisData, policy.Subsidy(0))
+		genesis := chain.genesis(cbtx)
 		fmt.Println("Genesis created")
 		err = txn.Set(genesis.Hash, genesis.Serialize())
 		Handle(err)
+		err = setChainWork(txn, genesis.Hash, engine.Weight(genesis))
+		Handle(err)
+		err = setHeightIndex(txn, genesis)
+		Handle(err)
+		err = UTXOSet{Blockchain: chain}.connectBlock(txn, genesis)
+		Handle(err)
+		err = setMonetaryPolicy(txn, policy)
+		Handle(err)
+		err = setConsensusConfig(txn, consensus)
+		Handle(err)
+		err = setNetwork(txn, chain.Params)
+		Handle(err)
+		err = setDBVersion(txn, latestDBVersion())
+		Handle(err)
 		err = txn.Set([]byte("lh"), genesis.Hash)
 
-		lastHash = genesis.Hash
+		chain.LastHash = genesis.Hash
+		chain.chainID = genesis.Hash
 
 		return err
 	})
 
 	Handle(err)
 
-	return &Blockchain{
-		LastHash: lastHash,
-		Database: db,
-		Logger:   logger,
-	}
+	return chain
 }
 
+// ValidateBlock checks that block can be connected on top of the active
+// tip. Its transactions are validated against the UTXO set when the block
+// is connected.
 func (chain *Blockchain) ValidateBlock(block *Block) error {
 	chain.Logger.Infow("block_validation_started", "hash", block.GetHash())
 
@@ -138,42 +230,53 @@ func (chain *Blockchain) ValidateBlock(block *Block) error {
 		return err
 	}
 
-	if block.Height != lastBlock.Height+1 {
-		chain.Logger.Warnw("new_block_height_invalid",
-			"new_block_hash", fmt.Sprintf("%x", block.Hash),
-			"new_block_height", block.Height,
-			"last_block_hash", lastBlock.Height,
-		)
-	}
-
 	if !bytes.Equal(block.PrevHash, lastBlock.Hash) {
 		chain.Logger.Warnw("block_prevhash_doesnt_match",
 			"last_block_hash", fmt.Sprintf("%x", lastBlock.Hash),
 			"new_block_prev_hash", fmt.Sprintf("%x", block.PrevHash),
 		)
-		return fmt.Errorf("block prev hash %x doesnt match last block hash %x", block.PrevHash, lastBlock.Hash)
+		return ruleError(RejectBadPrevHash, block.GetHash(), "prev hash %x doesnt match last block hash %x", block.PrevHash, lastBlock.Hash)
 	}
 
-	for _, tx := range block.Transactions {
-		if !chain.VerifyTransaction(tx) {
-			chain.Logger.Warnw("invalid_transaction",
-				"block_hash", block.GetHash(),
-				"tx", tx,
-			)
+	if err := chain.checkBlockHeader(block, lastBlock); err != nil {
+		return err
+	}
 
-			return fmt.Errorf("invalid block %s tx %s", block.GetHash(), tx.GetID())
-		}
+	chain.Logger.Infow("block_validation_completed", "hash", block.GetHash())
+
+	return nil
+}
+
+// checkBlockHeader validates the parts of a block that depend only on its
+// parent, so it can be used for side branch blocks as well.
+func (chain *Blockchain) checkBlockHeader(block *Block, parent *Block) error {
+	if err := checkHeaderCommitments(block); err != nil {
+		chain.Logger.Warnw("block_header_invalid",
+			"hash", block.GetHash(),
+			"error", err,
+		)
+		return err
 	}
 
-	pow := NewProof(block)
-	if !pow.Validate() {
-		chain.Logger.Warnw("block_pow_validation_failed", "hash", block.GetHash())
-		return fmt.Errorf("block %s pow validation failed", block.GetHash())
+	if block.Height != parent.Height+1 {
+		chain.Logger.Warnw("new_block_height_invalid",
+			"new_block_hash", fmt.Sprintf("%x", block.Hash),
+			"new_block_height", block.Height,
+			"parent_block_height", parent.Height,
+		)
+		return ruleError(RejectBadHeight, block.GetHash(), "height %d doesnt follow parent height %d", block.Height, parent.Height)
 	}
 
-	chain.Logger.Infow("block_validation_completed", "hash", block.GetHash())
+	if err := chain.checkBlockTime(block, parent); err != nil {
+		chain.Logger.Warnw("block_timestamp_invalid",
+			"hash", block.GetHash(),
+			"timestamp", block.Timestamp,
+			"error", err,
+		)
+		return err
+	}
 
-	return nil
+	return chain.Consensus.VerifySeal(chain, block, parent)
 }
 
 func (chain *Blockchain) GetLastBlock() (*Block, error) {
@@ -201,45 +304,92 @@ func (chain *Blockchain) GetLastBlock() (*Block, error) {
 	return block, nil
 }
 
-func (chain *Blockchain) AddBlock(block *Block) error {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get(block.Hash)
+// AddBlock stores block and runs fork choice. Blocks that don't extend the
+// branch with the most cumulative work are kept as side branch blocks; if
+// block makes its branch the heaviest, the chain is reorganized onto it.
+// ErrOrphanBlock is returned when the parent of block is not known yet.
+func (chain *Blockchain) AddBlock(block *Block) (*ChainUpdate, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
 
-		if err == nil {
-			chain.Logger.Infow("block_already_exists",
-				"hash", fmt.Sprintf("%x", block.Hash),
-				"height", block.Height,
-			)
-			return nil
-		}
+	if chain.BlockExists(block.Hash) {
+		chain.Logger.Infow("block_already_exists",
+			"hash", fmt.Sprintf("%x", block.Hash),
+			"height", block.Height,
+		)
+		return &ChainUpdate{}, nil
+	}
 
-		if err := chain.ValidateBlock(block); err != nil {
-			return err
-		}
+	if chain.isInvalid(block.PrevHash) {
+		chain.Logger.Warnw("block_extends_invalid_branch",
+			"hash", block.GetHash(),
+			"prev_hash", fmt.Sprintf("%x", block.PrevHash),
+		)
+		chain.markInvalid(block.Hash)
+		return &ChainUpdate{}, ErrInvalidChain
+	}
 
-		blockData := block.Serialize()
-		err = txn.Set(block.Hash, blockData)
-		if err != nil {
-			chain.Logger.Panicw("adding_block_to_db_failed",
-				"hash", fmt.Sprintf("%x", block.Hash),
-				"height", block.Height,
-				"error", err,
-			)
-		}
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		chain.Logger.Infow("orphan_block_received",
+			"hash", block.GetHash(),
+			"prev_hash", fmt.Sprintf("%x", block.PrevHash),
+		)
+		return &ChainUpdate{}, ErrOrphanBlock
+	}
 
-		err = txn.Set([]byte("lh"), block.Hash)
-		Handle(err)
-		chain.LastHash = block.Hash
+	if err := chain.checkBlockHeader(block, &parent); err != nil {
+		chain.logRejectedBlock(block, err)
+		return &ChainUpdate{}, err
+	}
 
-		chain.Logger.Infow("added_new_block",
+	parentWork, err := chain.GetChainWork(block.PrevHash)
+	if err != nil {
+		return &ChainUpdate{}, err
+	}
+	work := new(big.Int).Add(parentWork, chain.Consensus.Weight(block))
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		return setChainWork(txn, block.Hash, work)
+	})
+	if err != nil {
+		chain.Logger.Panicw("adding_block_to_db_failed",
 			"hash", fmt.Sprintf("%x", block.Hash),
 			"height", block.Height,
+			"error", err,
 		)
+	}
 
-		return nil
-	})
+	tipWork, err := chain.GetChainWork(chain.LastHash)
+	if err != nil {
+		return &ChainUpdate{}, err
+	}
 
-	return err
+	if work.Cmp(tipWork) <= 0 {
+		chain.Logger.Infow("side_branch_block_stored",
+			"hash", block.GetHash(),
+			"height", block.Height,
+			"chain_work", work.String(),
+			"tip_chain_work", tipWork.String(),
+		)
+		return &ChainUpdate{}, nil
+	}
+
+	update, err := chain.reorganize(block)
+	if err != nil {
+		return update, err
+	}
+
+	chain.Logger.Infow("added_new_block",
+		"hash", fmt.Sprintf("%x", block.Hash),
+		"height", block.Height,
+		"chain_work", work.String(),
+	)
+
+	return update, nil
 }
 
 func (chain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
@@ -264,23 +414,17 @@ func (chain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 }
 
 func (chain *Blockchain) GetBlockByHeight(height int) (*Block, error) {
-	var block *Block
-
-	iter := chain.Iterator()
-
-	for {
-		block = iter.Next()
-
-		if block.Height == height {
-			return block, nil
-		}
+	hash, err := chain.GetBlockHashByHeight(height)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("block at height - %d not found", height)
+	return &block, nil
 }
 
 func (chain *Blockchain) BlockExists(blockHash []byte) bool {
@@ -320,6 +464,11 @@ func (chain *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
+// ChainID identifies the chain in signatures. It is the genesis block hash.
+func (chain *Blockchain) ChainID() []byte {
+	return chain.chainID
+}
+
 func (chain *Blockchain) GetBestHeight() int {
 	var lastBlock Block
 
@@ -338,17 +487,23 @@ func (chain *Blockchain) GetBestHeight() int {
 	return lastBlock.Height
 }
 
-func (chain *Blockchain) MineBlock(transactions []*Transaction) *Block {
+var ErrInvalidTransaction = errors.New("transaction cant be included on top of the tip")
+
+// MineBlock builds a block with transactions on top of the active tip and
+// seals it with the consensus engine of the chain. It gives up with the
+// context error once ctx is done, for example because another block became
+// the tip, and with ErrInvalidTransaction if one of the transactions
+// doesn't fit the tip. The block is not stored; pass it to AddBlock so that
+// it goes through fork choice.
+func (chain *Blockchain) MineBlock(ctx context.Context, transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
-	var lastHeight int
+	var lastBlock *Block
 	for _, tx := range transactions {
 		chain.Logger.Infow("transaction_verification_started",
 			"tx_id", fmt.Sprintf("%x", tx.ID),
 		)
 		if !chain.VerifyTransaction(tx) {
-			chain.Logger.Panicw("invalid_transaction",
-				"transaction", tx,
-			)
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTransaction, tx.GetID())
 		}
 		chain.Logger.Infow("transaction_verified",
 			"transaction", tx,
@@ -378,64 +533,63 @@ func (chain *Blockchain) MineBlock(transactions []*Transaction) *Block {
 			},
 		)
 
-		lastBlock := Deserialize(lastBlockData)
-		lastHeight = lastBlock.Height
+		lastBlock = Deserialize(lastBlockData)
 
 		return err
 	})
 	Handle(err)
 
-	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		Handle(err)
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+	timestamp, err := chain.NextBlockTime(lastBlock)
+	Handle(err)
 
-		chain.LastHash = newBlock.Hash
+	block := newBlock(transactions, lastHash, lastBlock.Height+1, timestamp)
 
-		return err
-	})
-	Handle(err)
+	if err := chain.Consensus.Prepare(chain, block, lastBlock); err != nil {
+		return nil, err
+	}
 
-	chain.Logger.Infow("new_block_mined",
-		"block_hash", newBlock.GetHash(),
-		"block_height", newBlock.Height,
-	)
+	if err := chain.Consensus.Seal(ctx, chain, block); err != nil {
+		return nil, err
+	}
 
-	return newBlock
+	return block, nil
 }
 
-func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
-	UTXO := make(map[string]TxOutputs)
-	spentTXOs := make(map[string][]int)
+// FindUTXO rebuilds the unspent outputs of the active chain by walking it
+// from the tip back to genesis.
+func (chain *Blockchain) FindUTXO() []UTXO {
+	var UTXOs []UTXO
+	spentTXOs := make(map[string]bool)
 
 	iter := chain.Iterator()
 
 	for {
 		block := iter.Next()
 
+		// Inputs go first so that outputs spent later in the same block
+		// are skipped too.
 		for _, tx := range block.Transactions {
-			txID := tx.GetID()
-
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					spentTXOs[Outpoint{ID: in.ID, Index: in.Out}.String()] = true
 				}
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
 			}
+		}
 
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					inTxID := hex.EncodeToString(in.ID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
+		for _, tx := range block.Transactions {
+			for outIdx, out := range tx.Outputs {
+				outpoint := Outpoint{ID: tx.ID, Index: outIdx}
+
+				if spentTXOs[outpoint.String()] || IsUnspendable(out.LockingScript()) {
+					continue
 				}
+
+				UTXOs = append(UTXOs, UTXO{
+					Outpoint: outpoint,
+					Output:   out,
+					Height:   block.Height,
+					Coinbase: tx.IsCoinbase(),
+				})
 			}
 		}
 
@@ -444,41 +598,37 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 		}
 	}
 
-	return UTXO
+	return UTXOs
 }
 
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
-
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
-			}
-		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
+	lookup, err := bc.LookupTransaction(ID)
+	if err != nil {
+		return Transaction{}, err
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return *lookup.Transaction, nil
 }
 
+// SignTransaction signs tx with privKey. The outputs tx spends are looked
+// up in the UTXO set.
 func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ed25519.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+	prevOuts := make(map[string]TxOutput)
+	UTXOSet := UTXOSet{Blockchain: bc}
 
 	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
+		outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+		utxo, err := UTXOSet.GetUTXO(outpoint)
 		Handle(err)
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		prevOuts[outpoint.String()] = utxo.Output
 	}
 
-	tx.Sign(privKey, prevTXs)
+	tx.Sign(privKey, prevOuts, bc.ChainID())
 }
 
+// VerifyTransaction reports whether tx could be included in a block on top
+// of the active tip. See CheckTransaction for the rejection reason.
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	if tx.IsCoinbase() {
 		bc.Logger.Infow("skipping_coinbase_transaction_verification",
@@ -487,41 +637,23 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 		return true
 	}
 
-	prevTXs := make(map[string]Transaction)
-
-	bc.Logger.Infow("verifying_tx_inputs",
-		"tx_id", tx.GetID(),
-	)
-
-	for _, in := range tx.Inputs {
-		prevTX, err := bc.FindTransaction(in.ID)
-
-		bc.Logger.Infow("got_db_result",
-			"tx_id", tx.GetID(),
-			"err", err,
-		)
+	if err := bc.CheckTransaction(tx); err != nil {
+		reason, _ := RejectReasonOf(err)
 
-		if err != nil {
-			bc.Logger.Panicw("transaction_verification_failed",
-				"error", err,
-			)
-		}
-
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-
-		bc.Logger.Infow("added_tx_to_prev_txs",
+		bc.Logger.Warnw("transaction_verification_failed",
 			"tx_id", tx.GetID(),
+			"reason", reason,
+			"error", err,
 		)
+		return false
 	}
-	res := tx.Verify(prevTXs)
 
 	bc.Logger.Infow("tx_verification_result",
 		"tx_id", tx.GetID(),
-		"result", res,
+		"result", true,
 	)
 
-	return res
-
+	return true
 }
 
 func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Consensus engines a chain can be created with.
+const (
+	ProofOfWorkEngine      = "pow"
+	ProofOfAuthorityEngine = "poa"
+)
+
+var (
+	consensusConfigKey = []byte("consensus")
+
+	// DefaultConsensusConfig is used by chains created without explicit
+	// settings, including chains created before the engine was stored.
+	DefaultConsensusConfig = ConsensusConfig{Engine: ProofOfWorkEngine}
+)
+
+// Consensus decides which blocks may extend the chain and which branch
+// wins fork choice.
+type Consensus interface {
+	// Name is the engine the chain was created with.
+	Name() string
+
+	// Prepare sets the consensus fields of block, which is built on top of
+	// parent, or is the genesis block if parent is nil.
+	Prepare(chain *Blockchain, block, parent *Block) error
+
+	// Seal sets the hash of a prepared block and whatever proves it may
+	// extend the chain. It stops early with the context error once ctx is
+	// done.
+	Seal(ctx context.Context, chain *Blockchain, block *Block) error
+
+	// VerifySeal checks the consensus fields and the seal of block on top
+	// of parent, or of the genesis block if parent is nil.
+	VerifySeal(chain *Blockchain, block, parent *Block) error
+
+	// Weight is what block adds to its branch; the branch with the most
+	// cumulative weight is the active chain.
+	Weight(block *Block) *big.Int
+}
+
+// ConsensusConfig is the consensus engine a chain was created with and
+// its settings. Validators holds the ed25519 public keys allowed to sign
+// proof of authority blocks, in the order they take turns.
+type ConsensusConfig struct {
+	Engine     string
+	Validators [][]byte
+}
+
+func (c ConsensusConfig) Validate() error {
+	switch c.Engine {
+	case ProofOfWorkEngine:
+		if len(c.Validators) > 0 {
+			return errors.New("proof of work chains have no validators")
+		}
+	case ProofOfAuthorityEngine:
+		if len(c.Validators) == 0 {
+			return errors.New("proof of authority chains need at least one validator")
+		}
+
+		seen := make(map[string]bool)
+		for _, pubKey := range c.Validators {
+			if len(pubKey) != ed25519.PublicKeySize {
+				return fmt.Errorf("validator key %x isnt an ed25519 public key", pubKey)
+			}
+			if seen[string(pubKey)] {
+				return fmt.Errorf("validator key %x is listed twice", pubKey)
+			}
+			seen[string(pubKey)] = true
+		}
+	default:
+		return fmt.Errorf("unknown consensus engine %q", c.Engine)
+	}
+
+	return nil
+}
+
+// NewConsensus returns the engine configured by c.
+func NewConsensus(c ConsensusConfig) (Consensus, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.Engine == ProofOfAuthorityEngine {
+		return &ProofOfAuthority{Validators: c.Validators}, nil
+	}
+
+	return &ProofOfWorkConsensus{}, nil
+}
+
+func (c ConsensusConfig) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+
+	err := encoder.Encode(c)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func setConsensusConfig(txn *badger.Txn, config ConsensusConfig) error {
+	return txn.Set(consensusConfigKey, config.Serialize())
+}
+
+func (chain *Blockchain) loadConsensusConfig() (ConsensusConfig, error) {
+	config := DefaultConsensusConfig
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(consensusConfigKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		config = ConsensusConfig{}
+
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(&config)
+	})
+
+	return config, err
+}
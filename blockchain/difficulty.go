@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// maxRetargetFactor limits how much the target can move in one step.
+const maxRetargetFactor = 4
+
+// CompactToBig expands a target stored in the 32 bit compact form used by
+// Block.Bits: the high byte is the length of the number in bytes and the
+// low 23 bits are its most significant bytes.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(int64(mantissa))
+	} else {
+		n = big.NewInt(int64(mantissa))
+		n.Lsh(n, 8*(exponent-3))
+	}
+
+	if isNegative {
+		n = n.Neg(n)
+	}
+
+	return n
+}
+
+// BigToCompact converts a target to its compact form. Precision below the
+// three most significant bytes is lost.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Abs(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Uint64())
+	}
+
+	// The sign bit is part of the mantissa, so a set high bit has to be
+	// moved into the exponent.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// DifficultyToBits returns the compact target requiring zeroBits leading
+// zero bits in the block hash.
+func DifficultyToBits(zeroBits int) uint32 {
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), uint(256-zeroBits)))
+}
+
+// NextBits returns the target a block built on top of parent has to meet.
+// Every RetargetInterval blocks of the network the target is scaled by the
+// ratio between the observed and the expected duration of the last window.
+func (chain *Blockchain) NextBits(parent *Block) (uint32, error) {
+	height := parent.Height + 1
+	interval := chain.Params.RetargetInterval
+
+	if interval == 0 || height%interval != 0 {
+		return parent.Bits, nil
+	}
+
+	first, err := chain.getAncestor(parent, height-interval)
+	if err != nil {
+		return 0, err
+	}
+
+	expected := int64(time.Duration(interval-1) * chain.Params.TargetBlockTime / time.Second)
+	actual := parent.Timestamp - first.Timestamp
+
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	target := CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	// The easiest target the network allows.
+	powLimit := new(big.Int).Lsh(big.NewInt(1), uint(256-chain.Params.MinDifficulty))
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+
+	bits := BigToCompact(target)
+
+	chain.Logger.Debugw("difficulty_retargeted",
+		"height", height,
+		"actual_timespan", actual,
+		"expected_timespan", expected,
+		"old_bits", fmt.Sprintf("%08x", parent.Bits),
+		"new_bits", fmt.Sprintf("%08x", bits),
+	)
+
+	return bits, nil
+}
+
+// getAncestor walks back from block along its own branch to height.
+func (chain *Blockchain) getAncestor(block *Block, height int) (*Block, error) {
+	if height < 0 || height > block.Height {
+		return nil, fmt.Errorf("block %s has no ancestor at height %d", block.GetHash(), height)
+	}
+
+	for block.Height > height {
+		parent, err := chain.GetBlock(block.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		block = &parent
+	}
+
+	return block, nil
+}
@@ -0,0 +1,514 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EncodingVersion is the first byte of every encoded transaction, and of
+// blocks encoded before they had a seal.
+// Older builds stored gob streams, which never start with a byte between
+// 0x80 and 0xf7, so versions are numbered from 0x81 and both formats can be
+// told apart while migrating.
+//
+// All integers are big endian with a fixed width. Byte strings and lists
+// are prefixed with their length as an unsigned varint.
+//
+// Transaction:
+//
+//	byte    encoding version
+//	bytes   ID
+//	int32   version          \
+//	int64   timestamp         |
+//	varint  input count       |
+//	  bytes   previous txid   |
+//	  int32   output index    |  body, hashed into the ID
+//	  bytes   unlocking script|
+//	  uint32  sequence        |
+//	varint  output count      |
+//	  int64   value           |
+//	  bytes   locking script  |
+//	uint32  lock time         /
+//
+// Transactions below version 4 have no sequences and lock time. Below
+// version 3 they encode the signature and public key of an input instead of
+// its unlocking script, and the public key hash of an output instead of its
+// locking script.
+//
+// Block:
+//
+//	byte    encoding version
+//	int32   version
+//	bytes   previous hash
+//	bytes   merkle root
+//	int64   timestamp
+//	uint32  bits
+//	int64   nonce
+//	int64   height
+//	bytes   hash
+//	varint  transaction count
+//	  bytes   encoded transaction
+//	bytes   seal
+//
+// Blocks are encoded with BlockEncodingVersion; blocks encoded with
+// EncodingVersion have no seal.
+//
+// Unspent output:
+//
+//	byte    encoding version
+//	bytes   txid
+//	int32   output index
+//	int64   value
+//	bytes   public key hash
+//	bytes   locking script
+//	int64   height
+//	byte    coinbase, 0 or 1
+//
+// Block undo record:
+//
+//	byte    encoding version
+//	varint  spent output count
+//	  ...     unspent output without the encoding version
+//
+// Transaction location:
+//
+//	byte    encoding version
+//	bytes   block hash
+//	int32   position
+//
+// Unspent outputs, undo records and transaction locations use
+// EncodingVersion.
+const EncodingVersion byte = 0x81
+
+// BlockEncodingVersion is the first byte of blocks that end with a seal.
+const BlockEncodingVersion byte = 0x82
+
+var (
+	ErrUnknownEncoding = errors.New("unknown encoding version")
+	errShortData       = errors.New("unexpected end of data")
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+func (e *encoder) varint(v int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.varint(len(v))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// decoder reads what encoder wrote. The first error sticks and makes every
+// following read return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errShortData
+		return nil
+	}
+
+	v := d.data[:n]
+	d.data = d.data[n:]
+
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) int64() int64 {
+	if v := d.take(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+
+	return 0
+}
+
+// varint reads a length. Every counted item takes at least one byte, so a
+// length beyond the remaining data is rejected before anything is allocated.
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 || v > uint64(len(d.data)-n) {
+		d.err = errShortData
+		return 0
+	}
+	d.data = d.data[n:]
+
+	return int(v)
+}
+
+// bytes returns nil for empty byte strings, like gob did, so that JSON
+// encoding old blocks hashed is reproduced.
+func (d *decoder) bytes() []byte {
+	n := d.varint()
+	v := d.take(n)
+	if len(v) == 0 {
+		return nil
+	}
+
+	return append([]byte{}, v...)
+}
+
+func (d *decoder) bool() bool {
+	v := d.byte()
+	if v > 1 && d.err == nil {
+		d.err = fmt.Errorf("boolean byte %#x isnt 0 or 1", v)
+	}
+
+	return v == 1
+}
+
+// version reads the encoding version of a record that only has one.
+func (d *decoder) version() error {
+	if version := d.byte(); d.err == nil && version != EncodingVersion {
+		return fmt.Errorf("%w %#x", ErrUnknownEncoding, version)
+	}
+
+	return nil
+}
+
+// finish reports the first error, or trailing data left after a record.
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.data) != 0 {
+		return fmt.Errorf("%d bytes of trailing data", len(d.data))
+	}
+
+	return nil
+}
+
+func (tx *Transaction) encodeBody(e *encoder) {
+	e.int32(tx.Version)
+	e.int64(tx.Timestamp)
+
+	e.varint(len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		e.bytes(in.ID)
+		e.int32(int32(in.Out))
+		if tx.Version < 3 {
+			e.bytes(in.Signature)
+			e.bytes(in.PubKey)
+		} else {
+			e.bytes(in.ScriptSig)
+		}
+		if tx.Version >= 4 {
+			e.uint32(in.Sequence)
+		}
+	}
+
+	e.varint(len(tx.Outputs))
+	for _, out := range tx.Outputs {
+		e.int64(int64(out.Value))
+		if tx.Version < 3 {
+			e.bytes(out.PubKeyHash)
+		} else {
+			e.bytes(out.ScriptPubKey)
+		}
+	}
+
+	if tx.Version >= 4 {
+		e.uint32(tx.LockTime)
+	}
+}
+
+func (tx *Transaction) decodeBody(d *decoder) {
+	tx.Version = d.int32()
+	tx.Timestamp = d.int64()
+
+	tx.Inputs = make([]TxInput, d.varint())
+	for i := range tx.Inputs {
+		in := &tx.Inputs[i]
+		in.ID = d.bytes()
+		in.Out = int(d.int32())
+		if tx.Version < 3 {
+			in.Signature = d.bytes()
+			in.PubKey = d.bytes()
+		} else {
+			in.ScriptSig = d.bytes()
+		}
+		if tx.Version >= 4 {
+			in.Sequence = d.uint32()
+		}
+	}
+
+	tx.Outputs = make([]TxOutput, d.varint())
+	for i := range tx.Outputs {
+		out := &tx.Outputs[i]
+		out.Value = int(d.int64())
+		if tx.Version < 3 {
+			out.PubKeyHash = d.bytes()
+		} else {
+			out.ScriptPubKey = d.bytes()
+		}
+	}
+
+	if tx.Version >= 4 {
+		tx.LockTime = d.uint32()
+	}
+}
+
+// EncodeTransaction returns the canonical encoding of tx.
+func EncodeTransaction(tx *Transaction) []byte {
+	e := &encoder{}
+
+	e.buf = append(e.buf, EncodingVersion)
+	e.bytes(tx.ID)
+	tx.encodeBody(e)
+
+	return e.buf
+}
+
+// DecodeTransaction parses a transaction encoded by EncodeTransaction.
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+
+	if version := d.byte(); d.err == nil && version != EncodingVersion {
+		return nil, fmt.Errorf("%w %#x", ErrUnknownEncoding, version)
+	}
+
+	tx := &Transaction{ID: d.bytes()}
+	tx.decodeBody(d)
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode transaction: %w", err)
+	}
+
+	return tx, nil
+}
+
+// EncodeBlock returns the canonical encoding of block.
+func EncodeBlock(block *Block) []byte {
+	e := &encoder{}
+
+	e.buf = append(e.buf, BlockEncodingVersion)
+	e.int32(block.Version)
+	e.bytes(block.PrevHash)
+	e.bytes(block.MerkleRoot)
+	e.int64(block.Timestamp)
+	e.uint32(block.Bits)
+	e.int64(int64(block.Nonce))
+	e.int64(int64(block.Height))
+	e.bytes(block.Hash)
+
+	e.varint(len(block.Transactions))
+	for _, tx := range block.Transactions {
+		e.bytes(EncodeTransaction(tx))
+	}
+
+	e.bytes(block.Seal)
+
+	return e.buf
+}
+
+// DecodeBlock parses a block encoded by EncodeBlock.
+func DecodeBlock(data []byte) (*Block, error) {
+	d := &decoder{data: data}
+
+	version := d.byte()
+	if d.err == nil && version != EncodingVersion && version != BlockEncodingVersion {
+		return nil, fmt.Errorf("%w %#x", ErrUnknownEncoding, version)
+	}
+
+	block := &Block{
+		Version:    d.int32(),
+		PrevHash:   d.bytes(),
+		MerkleRoot: d.bytes(),
+		Timestamp:  d.int64(),
+		Bits:       d.uint32(),
+		Nonce:      int(d.int64()),
+		Height:     int(d.int64()),
+		Hash:       d.bytes(),
+	}
+
+	block.Transactions = make([]*Transaction, d.varint())
+	for i := range block.Transactions {
+		data := d.bytes()
+		if d.err != nil {
+			break
+		}
+
+		tx, err := DecodeTransaction(data)
+		if err != nil {
+			return nil, fmt.Errorf("decode block transaction %d: %w", i, err)
+		}
+		block.Transactions[i] = tx
+	}
+
+	if version == BlockEncodingVersion {
+		block.Seal = d.bytes()
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("decode block: %w", err)
+	}
+
+	return block, nil
+}
+
+func (u UTXO) encode(e *encoder) {
+	e.bytes(u.Outpoint.ID)
+	e.int32(int32(u.Outpoint.Index))
+	e.int64(int64(u.Output.Value))
+	e.bytes(u.Output.PubKeyHash)
+	e.bytes(u.Output.ScriptPubKey)
+	e.int64(int64(u.Height))
+	e.bool(u.Coinbase)
+}
+
+func (u *UTXO) decode(d *decoder) {
+	u.Outpoint.ID = d.bytes()
+	u.Outpoint.Index = int(d.int32())
+	u.Output.Value = int(d.int64())
+	u.Output.PubKeyHash = d.bytes()
+	u.Output.ScriptPubKey = d.bytes()
+	u.Height = int(d.int64())
+	u.Coinbase = d.bool()
+}
+
+// EncodeUTXO returns the canonical encoding of an unspent output.
+func EncodeUTXO(u UTXO) []byte {
+	e := &encoder{buf: []byte{EncodingVersion}}
+	u.encode(e)
+
+	return e.buf
+}
+
+// DecodeUTXO parses an unspent output encoded by EncodeUTXO.
+func DecodeUTXO(data []byte) (UTXO, error) {
+	d := &decoder{data: data}
+	if err := d.version(); err != nil {
+		return UTXO{}, err
+	}
+
+	var u UTXO
+	u.decode(d)
+
+	if err := d.finish(); err != nil {
+		return UTXO{}, fmt.Errorf("decode unspent output: %w", err)
+	}
+
+	return u, nil
+}
+
+// EncodeBlockUndo returns the canonical encoding of an undo record.
+func EncodeBlockUndo(undo BlockUndo) []byte {
+	e := &encoder{buf: []byte{EncodingVersion}}
+
+	e.varint(len(undo.Spent))
+	for _, u := range undo.Spent {
+		u.encode(e)
+	}
+
+	return e.buf
+}
+
+// DecodeBlockUndo parses an undo record encoded by EncodeBlockUndo.
+func DecodeBlockUndo(data []byte) (BlockUndo, error) {
+	d := &decoder{data: data}
+	if err := d.version(); err != nil {
+		return BlockUndo{}, err
+	}
+
+	var undo BlockUndo
+	if n := d.varint(); n > 0 {
+		undo.Spent = make([]UTXO, n)
+		for i := range undo.Spent {
+			undo.Spent[i].decode(d)
+		}
+	}
+
+	if err := d.finish(); err != nil {
+		return BlockUndo{}, fmt.Errorf("decode undo record: %w", err)
+	}
+
+	return undo, nil
+}
+
+// EncodeTxLocation returns the canonical encoding of a transaction location.
+func EncodeTxLocation(loc TxLocation) []byte {
+	e := &encoder{buf: []byte{EncodingVersion}}
+	e.bytes(loc.BlockHash)
+	e.int32(int32(loc.Position))
+
+	return e.buf
+}
+
+// DecodeTxLocation parses a transaction location encoded by
+// EncodeTxLocation.
+func DecodeTxLocation(data []byte) (TxLocation, error) {
+	d := &decoder{data: data}
+	if err := d.version(); err != nil {
+		return TxLocation{}, err
+	}
+
+	loc := TxLocation{
+		BlockHash: d.bytes(),
+		Position:  int(d.int32()),
+	}
+
+	if err := d.finish(); err != nil {
+		return TxLocation{}, fmt.Errorf("decode transaction location: %w", err)
+	}
+
+	return loc, nil
+}
+
+// isLegacyEncoding reports whether data is a gob stream written by an
+// older build.
+func isLegacyEncoding(data []byte) bool {
+	return len(data) > 0 && (data[0] < 0x80 || data[0] >= 0xf8)
+}
@@ -0,0 +1,373 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testTransaction(version int32) *Transaction {
+	tx := &Transaction{
+		ID:        bytes.Repeat([]byte{0x11}, 32),
+		Timestamp: 1700000000000000000,
+		Version:   version,
+		Inputs: []TxInput{
+			{ID: bytes.Repeat([]byte{0x22}, 32), Out: 1},
+			{ID: bytes.Repeat([]byte{0x33}, 32), Out: 0},
+		},
+		Outputs: []TxOutput{
+			{Value: 50},
+			{Value: 7},
+		},
+	}
+
+	for i := range tx.Inputs {
+		in := &tx.Inputs[i]
+		if version < 3 {
+			in.Signature = bytes.Repeat([]byte{0x44}, 64)
+			in.PubKey = bytes.Repeat([]byte{0x55}, 32)
+		} else {
+			in.ScriptSig = bytes.Repeat([]byte{0x66}, 97)
+		}
+		if version >= 4 {
+			in.Sequence = 0xfffffffe - uint32(i)
+		}
+	}
+
+	for i := range tx.Outputs {
+		out := &tx.Outputs[i]
+		if version < 3 {
+			out.PubKeyHash = bytes.Repeat([]byte{0x77}, 20)
+		} else {
+			out.ScriptPubKey = bytes.Repeat([]byte{0x88}, 25)
+		}
+	}
+
+	if version >= 4 {
+		tx.LockTime = 120
+	}
+
+	return tx
+}
+
+func testBlock(seal []byte) *Block {
+	return &Block{
+		Version:      BlockVersion,
+		PrevHash:     bytes.Repeat([]byte{0x01}, 32),
+		MerkleRoot:   bytes.Repeat([]byte{0x02}, 32),
+		Timestamp:    1700000000,
+		Bits:         DifficultyToBits(12),
+		Nonce:        4242,
+		Height:       9,
+		Hash:         bytes.Repeat([]byte{0x03}, 32),
+		Transactions: []*Transaction{testTransaction(TxVersion), testTransaction(2)},
+		Seal:         seal,
+	}
+}
+
+func TestTransactionEncodingRoundTrip(t *testing.T) {
+	for _, version := range []int32{1, 2, 3, TxVersion} {
+		tx := testTransaction(version)
+
+		decoded, err := DecodeTransaction(EncodeTransaction(tx))
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if !reflect.DeepEqual(decoded, tx) {
+			t.Fatalf("version %d: decoded %+v, want %+v", version, decoded, tx)
+		}
+		if !bytes.Equal(decoded.Hash(), tx.Hash()) {
+			t.Fatalf("version %d: decoded transaction hashes to %x, want %x", version, decoded.Hash(), tx.Hash())
+		}
+	}
+}
+
+func TestTransactionEncodingDropsFieldsOfOtherVersions(t *testing.T) {
+	tx := testTransaction(2)
+	tx.Inputs[0].ScriptSig = []byte{0x01}
+	tx.Inputs[0].Sequence = 5
+	tx.Outputs[0].ScriptPubKey = []byte{0x02}
+	tx.LockTime = 10
+
+	decoded, err := DecodeTransaction(EncodeTransaction(tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, testTransaction(2)) {
+		t.Fatalf("decoded %+v, want only version 2 fields", decoded)
+	}
+}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	for _, seal := range [][]byte{nil, bytes.Repeat([]byte{0x99}, 96)} {
+		block := testBlock(seal)
+
+		data := EncodeBlock(block)
+		if data[0] != BlockEncodingVersion {
+			t.Fatalf("block encoded with version %#x, want %#x", data[0], BlockEncodingVersion)
+		}
+
+		decoded, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, block) {
+			t.Fatalf("decoded %+v, want %+v", decoded, block)
+		}
+	}
+}
+
+func TestBlockEncodingWithoutSeal(t *testing.T) {
+	block := testBlock(nil)
+
+	// Blocks encoded before seals existed start with EncodingVersion and
+	// end after the transactions.
+	data := EncodeBlock(block)
+	data = append([]byte{EncodingVersion}, data[1:len(data)-1]...)
+
+	decoded, err := DecodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, block) {
+		t.Fatalf("decoded %+v, want %+v", decoded, block)
+	}
+}
+
+func TestDecodeRejectsMalformedData(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		decode func([]byte) error
+		// lengthAt is the offset of the first length prefix, the one of the
+		// transaction ID or the previous block hash.
+		lengthAt int
+	}{
+		{
+			name:     "transaction",
+			data:     EncodeTransaction(testTransaction(TxVersion)),
+			decode:   func(data []byte) error { _, err := DecodeTransaction(data); return err },
+			lengthAt: 1,
+		},
+		{
+			name:     "block",
+			data:     EncodeBlock(testBlock(bytes.Repeat([]byte{0x99}, 96))),
+			decode:   func(data []byte) error { _, err := DecodeBlock(data); return err },
+			lengthAt: 5,
+		},
+	}
+
+	for _, test := range tests {
+		data := test.data
+
+		for n := 0; n < len(data); n++ {
+			if err := test.decode(data[:n]); err == nil {
+				t.Fatalf("%s truncated to %d of %d bytes decoded", test.name, n, len(data))
+			}
+		}
+
+		if err := test.decode(append(append([]byte{}, data...), 0x00)); err == nil {
+			t.Fatalf("%s with trailing data decoded", test.name)
+		}
+
+		// A length claiming more bytes than are left.
+		oversized := append(append([]byte{}, data[:test.lengthAt]...), 0xff, 0xff, 0x03)
+		oversized = append(oversized, data[test.lengthAt+1:]...)
+		if err := test.decode(oversized); !errors.Is(err, errShortData) {
+			t.Fatalf("%s with oversized length: got %v, want %v", test.name, err, errShortData)
+		}
+
+		// A length overflowing 64 bits.
+		overflow := append(append([]byte{}, data[:test.lengthAt]...), bytes.Repeat([]byte{0xff}, 10)...)
+		overflow = append(overflow, 0x01)
+		if err := test.decode(overflow); !errors.Is(err, errShortData) {
+			t.Fatalf("%s with overflowing length: got %v, want %v", test.name, err, errShortData)
+		}
+
+		unknown := append([]byte{0x90}, data[1:]...)
+		if err := test.decode(unknown); !errors.Is(err, ErrUnknownEncoding) {
+			t.Fatalf("%s with unknown version: got %v, want %v", test.name, err, ErrUnknownEncoding)
+		}
+	}
+}
+
+func TestDecodeRejectsOversizedCounts(t *testing.T) {
+	block := testBlock(nil)
+	block.Transactions = nil
+
+	// Cut the encoding right after the hash and claim a billion
+	// transactions.
+	data := EncodeBlock(block)
+	data = append(data[:len(data)-2], 0x80, 0x94, 0xeb, 0xdc, 0x03)
+
+	if _, err := DecodeBlock(data); !errors.Is(err, errShortData) {
+		t.Fatalf("got %v, want %v", err, errShortData)
+	}
+}
+
+// legacyBlock returns a block as older builds stored it: gob encoded, with
+// the given header version and the transactions they created.
+func legacyBlock(t *testing.T, version int32) (*Block, []byte) {
+	t.Helper()
+
+	coinbase := testTransaction(1)
+	coinbase.Inputs = []TxInput{{Out: -1, PubKey: []byte("legacy coinbase")}}
+	coinbase.Outputs = coinbase.Outputs[:1]
+
+	block := &Block{
+		Version:      version,
+		PrevHash:     bytes.Repeat([]byte{0x04}, 32),
+		Timestamp:    1600000000,
+		Nonce:        77,
+		Height:       3,
+		Transactions: []*Transaction{coinbase, testTransaction(2)},
+	}
+	if version > 0 {
+		block.Bits = DifficultyToBits(legacyDifficulty)
+		block.MerkleRoot = block.JsonHashTransactions()
+		block.Hash = block.ComputeHash()
+	} else {
+		// Version 0 hashes are written out here so the test doesn't
+		// depend on the code it checks.
+		hash := sha256.Sum256(bytes.Join([][]byte{
+			block.PrevHash,
+			block.JsonHashTransactions(),
+			ToHex(int64(block.Nonce)),
+			ToHex(18),
+		}, nil))
+		block.Hash = hash[:]
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(block); err != nil {
+		t.Fatal(err)
+	}
+
+	return block, buf.Bytes()
+}
+
+func TestLegacyBlockMigrationKeepsHash(t *testing.T) {
+	for _, version := range []int32{0, 1} {
+		legacy, data := legacyBlock(t, version)
+		if !isLegacyEncoding(data) {
+			t.Fatalf("version %d: gob stream starts with %#x, taken for a canonical encoding", version, data[0])
+		}
+
+		// migrateBlockBits stores the target of version 0 blocks, and
+		// migrateCanonicalEncoding re-encodes them.
+		block := Deserialize(data)
+		if block.Bits == 0 {
+			block.Bits = DifficultyToBits(legacyDifficulty)
+		}
+
+		migrated := Deserialize(block.Serialize())
+
+		if !bytes.Equal(migrated.Hash, legacy.Hash) {
+			t.Fatalf("version %d: migrated hash %x, want %x", version, migrated.Hash, legacy.Hash)
+		}
+		if hash := migrated.ComputeHash(); !bytes.Equal(hash, legacy.Hash) {
+			t.Fatalf("version %d: migrated block hashes to %x, want %x", version, hash, legacy.Hash)
+		}
+		if migrated.Bits != DifficultyToBits(legacyDifficulty) {
+			t.Fatalf("version %d: migrated bits %#x, want %#x", version, migrated.Bits, DifficultyToBits(legacyDifficulty))
+		}
+	}
+}
+
+func TestDecodeBlockRejectsLegacyEncoding(t *testing.T) {
+	_, data := legacyBlock(t, 1)
+
+	if _, err := DecodeBlock(data); !errors.Is(err, ErrUnknownEncoding) {
+		t.Fatalf("got %v, want %v", err, ErrUnknownEncoding)
+	}
+}
+
+func testUTXO(coinbase bool) UTXO {
+	return UTXO{
+		Outpoint: Outpoint{ID: bytes.Repeat([]byte{0x05}, 32), Index: 3},
+		Output:   TxOutput{Value: 25, ScriptPubKey: bytes.Repeat([]byte{0x06}, 25)},
+		Height:   17,
+		Coinbase: coinbase,
+	}
+}
+
+func TestRecordEncodingRoundTrip(t *testing.T) {
+	legacyOutput := testUTXO(true)
+	legacyOutput.Output = TxOutput{Value: 9, PubKeyHash: bytes.Repeat([]byte{0x07}, 20)}
+
+	for _, u := range []UTXO{testUTXO(false), testUTXO(true), legacyOutput} {
+		decoded, err := DecodeUTXO(EncodeUTXO(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, u) {
+			t.Fatalf("decoded %+v, want %+v", decoded, u)
+		}
+	}
+
+	for _, undo := range []BlockUndo{{}, {Spent: []UTXO{testUTXO(false), legacyOutput}}} {
+		decoded, err := DecodeBlockUndo(EncodeBlockUndo(undo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, undo) {
+			t.Fatalf("decoded %+v, want %+v", decoded, undo)
+		}
+	}
+
+	loc := TxLocation{BlockHash: bytes.Repeat([]byte{0x08}, 32), Position: 2}
+	decoded, err := DecodeTxLocation(EncodeTxLocation(loc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, loc) {
+		t.Fatalf("decoded %+v, want %+v", decoded, loc)
+	}
+}
+
+func TestDecodeRecordRejectsMalformedData(t *testing.T) {
+	records := map[string]struct {
+		data   []byte
+		decode func([]byte) error
+	}{
+		"unspent output": {
+			EncodeUTXO(testUTXO(true)),
+			func(data []byte) error { _, err := DecodeUTXO(data); return err },
+		},
+		"undo record": {
+			EncodeBlockUndo(BlockUndo{Spent: []UTXO{testUTXO(false)}}),
+			func(data []byte) error { _, err := DecodeBlockUndo(data); return err },
+		},
+		"transaction location": {
+			EncodeTxLocation(TxLocation{BlockHash: bytes.Repeat([]byte{0x08}, 32), Position: 2}),
+			func(data []byte) error { _, err := DecodeTxLocation(data); return err },
+		},
+	}
+
+	for name, record := range records {
+		for n := 0; n < len(record.data); n++ {
+			if err := record.decode(record.data[:n]); err == nil {
+				t.Fatalf("%s truncated to %d of %d bytes decoded", name, n, len(record.data))
+			}
+		}
+
+		if err := record.decode(append(append([]byte{}, record.data...), 0x00)); err == nil {
+			t.Fatalf("%s with trailing data decoded", name)
+		}
+
+		unknown := append([]byte{BlockEncodingVersion}, record.data[1:]...)
+		if err := record.decode(unknown); !errors.Is(err, ErrUnknownEncoding) {
+			t.Fatalf("%s with unknown version: got %v, want %v", name, err, ErrUnknownEncoding)
+		}
+	}
+
+	// The coinbase flag is the last byte of an unspent output.
+	data := EncodeUTXO(testUTXO(true))
+	data[len(data)-1] = 2
+	if _, err := DecodeUTXO(data); err == nil {
+		t.Fatal("unspent output with coinbase flag 2 decoded")
+	}
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// ExtraNonceSize is the length of the extra nonce a coinbase input script
+// starts with.
+const ExtraNonceSize = 8
+
+var ErrNoExtraNonce = errors.New("block has no coinbase with an extra nonce")
+
+// coinbaseScript returns the coinbase input script: a push of the extra
+// nonce followed by a push of data, if there is any.
+func coinbaseScript(extraNonce uint64, data []byte) []byte {
+	b := NewScriptBuilder().AddData(binary.BigEndian.AppendUint64(nil, extraNonce))
+	if len(data) > 0 {
+		b.AddData(data)
+	}
+
+	return b.Script()
+}
+
+// randomExtraNonce returns the extra nonce a new coinbase starts from, so
+// that two coinbases paying the same reward to the same address still have
+// different IDs.
+func randomExtraNonce() uint64 {
+	var buf [ExtraNonceSize]byte
+	_, err := rand.Read(buf[:])
+	Handle(err)
+
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// ExtraNonce returns the extra nonce of a coinbase. Coinbases made by
+// older builds have none.
+func (tx *Transaction) ExtraNonce() (uint64, bool) {
+	if !tx.IsCoinbase() {
+		return 0, false
+	}
+
+	ops, err := parseScript(tx.Inputs[0].ScriptSig)
+	if err != nil || len(ops) == 0 || len(ops) > 2 || len(ops[0].data) != ExtraNonceSize {
+		return 0, false
+	}
+	if len(ops) == 2 && !ops[1].isPush() {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(ops[0].data), true
+}
+
+// SetExtraNonce replaces the extra nonce of a coinbase and updates its ID.
+func (tx *Transaction) SetExtraNonce(extraNonce uint64) error {
+	if _, ok := tx.ExtraNonce(); !ok {
+		return ErrNoExtraNonce
+	}
+
+	script := tx.Inputs[0].ScriptSig
+	binary.BigEndian.PutUint64(script[1:1+ExtraNonceSize], extraNonce)
+	tx.ID = tx.Hash()
+
+	return nil
+}
+
+// IncrementExtraNonce rolls the extra nonce of the coinbase and recomputes
+// the merkle root, which gives the miner a fresh header to search once the
+// nonces are used up.
+func (b *Block) IncrementExtraNonce() error {
+	for _, tx := range b.Transactions {
+		extraNonce, ok := tx.ExtraNonce()
+		if !ok {
+			continue
+		}
+
+		if err := tx.SetExtraNonce(extraNonce + 1); err != nil {
+			return err
+		}
+		b.MerkleRoot = b.HashTransactions()
+
+		return nil
+	}
+
+	return ErrNoExtraNonce
+}
@@ -0,0 +1,333 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	workPrefix    = []byte("work-")
+	invalidPrefix = []byte("invalid-")
+
+	ErrOrphanBlock  = errors.New("block parent is unknown")
+	ErrInvalidChain = errors.New("block is part of an invalidated branch")
+)
+
+// ChainUpdate describes how the active chain changed after a block was added.
+// Disconnected blocks are ordered from the old tip down to the fork point,
+// Connected blocks from the fork point up to the new tip.
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+func (u *ChainUpdate) IsEmpty() bool {
+	return len(u.Disconnected) == 0 && len(u.Connected) == 0
+}
+
+func (u *ChainUpdate) IsReorg() bool {
+	return len(u.Disconnected) > 0
+}
+
+func workKey(hash []byte) []byte {
+	return append(append([]byte{}, workPrefix...), hash...)
+}
+
+func setChainWork(txn *badger.Txn, hash []byte, work *big.Int) error {
+	return txn.Set(workKey(hash), work.Bytes())
+}
+
+// GetChainWork returns the total proof-of-work of the branch ending at hash.
+func (chain *Blockchain) GetChainWork(hash []byte) (*big.Int, error) {
+	work := new(big.Int)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(workKey(hash))
+		if err != nil {
+			return fmt.Errorf("chain work for block %x not found: %w", hash, err)
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		work.SetBytes(val)
+
+		return nil
+	})
+
+	return work, err
+}
+
+// findFork walks the active chain and the branch ending at newTip back to
+// their common ancestor.
+func (chain *Blockchain) findFork(newTip *Block) (detach []*Block, attach []*Block, err error) {
+	oldTip, err := chain.GetLastBlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parent := func(b *Block) (*Block, error) {
+		if len(b.PrevHash) == 0 {
+			return nil, fmt.Errorf("block %s has no common ancestor with active chain", newTip.GetHash())
+		}
+		p, err := chain.GetBlock(b.PrevHash)
+		return &p, err
+	}
+
+	a, b := oldTip, newTip
+
+	for a.Height > b.Height {
+		detach = append(detach, a)
+		if a, err = parent(a); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for b.Height > a.Height {
+		attach = append(attach, b)
+		if b, err = parent(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for !bytes.Equal(a.Hash, b.Hash) {
+		detach = append(detach, a)
+		attach = append(attach, b)
+
+		if a, err = parent(a); err != nil {
+			return nil, nil, err
+		}
+		if b, err = parent(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, j := 0, len(attach)-1; i < j; i, j = i+1, j-1 {
+		attach[i], attach[j] = attach[j], attach[i]
+	}
+
+	return detach, attach, nil
+}
+
+// reorganize makes newTip the active tip. Blocks of the old branch are
+// disconnected down to the fork point and blocks of the new branch are
+// validated and connected one by one. If a block can't be disconnected or
+// connected, the old branch is restored.
+func (chain *Blockchain) reorganize(newTip *Block) (*ChainUpdate, error) {
+	detach, attach, err := chain.findFork(newTip)
+	if err != nil {
+		return &ChainUpdate{}, err
+	}
+
+	if len(detach) > 0 {
+		chain.Logger.Warnw("chain_reorganization_started",
+			"old_tip", fmt.Sprintf("%x", chain.LastHash),
+			"new_tip", newTip.GetHash(),
+			"disconnect_len", len(detach),
+			"connect_len", len(attach),
+		)
+	}
+
+	update := &ChainUpdate{}
+
+	for range detach {
+		block, err := chain.disconnectTip()
+		if err != nil {
+			chain.Logger.Warnw("chain_reorganization_failed",
+				"tip", fmt.Sprintf("%x", chain.LastHash),
+				"error", err,
+			)
+
+			return &ChainUpdate{}, chain.abortReorganization(update, err)
+		}
+		update.Disconnected = append(update.Disconnected, block)
+	}
+
+	for i, block := range attach {
+		if err := chain.connectBlock(block); err != nil {
+			chain.Logger.Warnw("chain_reorganization_failed",
+				"invalid_block", block.GetHash(),
+				"error", err,
+			)
+
+			// The blocks built on an invalid block are invalid too, which
+			// keeps their children from triggering the same reorganization.
+			if isPermanentRejection(err) {
+				for _, invalid := range attach[i:] {
+					chain.markInvalid(invalid.Hash)
+				}
+			}
+
+			return &ChainUpdate{}, chain.abortReorganization(update, err)
+		}
+
+		update.Connected = append(update.Connected, block)
+	}
+
+	if update.IsReorg() {
+		chain.Logger.Warnw("chain_reorganization_completed",
+			"new_tip", newTip.GetHash(),
+			"height", newTip.Height,
+		)
+	}
+
+	return update, nil
+}
+
+// abortReorganization restores the active chain after the reorganization
+// failed with err, and returns the error to report.
+func (chain *Blockchain) abortReorganization(update *ChainUpdate, err error) error {
+	if restoreErr := chain.restoreBranch(update); restoreErr != nil {
+		chain.Logger.Errorw("restoring_active_chain_failed",
+			"tip", fmt.Sprintf("%x", chain.LastHash),
+			"error", restoreErr,
+		)
+		return fmt.Errorf("%w, restoring active chain failed: %v", err, restoreErr)
+	}
+
+	return err
+}
+
+// restoreBranch undoes a partially applied update. The disconnected blocks
+// were valid when they were connected, so they are reconnected without
+// checking them again: older blocks don't meet every rule added since.
+func (chain *Blockchain) restoreBranch(update *ChainUpdate) error {
+	for range update.Connected {
+		if _, err := chain.disconnectTip(); err != nil {
+			return err
+		}
+	}
+
+	for i := len(update.Disconnected) - 1; i >= 0; i-- {
+		if err := chain.setTip(update.Disconnected[i], UTXOSet.reconnectBlock); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// connectBlock validates block against the active tip and makes it the new
+// tip.
+func (chain *Blockchain) connectBlock(block *Block) error {
+	if err := chain.ValidateBlock(block); err != nil {
+		chain.logRejectedBlock(block, err)
+		return err
+	}
+
+	return chain.setTip(block, UTXOSet.connectBlock)
+}
+
+// setTip makes block, a child of the active tip, the new tip. The block's
+// index entries, the UTXO set changes made by applyUTXO and the new tip are
+// written in one database transaction, so a crash can't leave them apart.
+func (chain *Blockchain) setTip(block *Block, applyUTXO func(UTXOSet, *badger.Txn, *Block) error) error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		if err := setHeightIndex(txn, block); err != nil {
+			return err
+		}
+		if chain.txIndex {
+			if err := indexBlockTransactions(txn, block); err != nil {
+				return err
+			}
+		}
+		if err := applyUTXO(UTXOSet{Blockchain: chain}, txn, block); err != nil {
+			return err
+		}
+		return txn.Set([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		chain.logRejectedBlock(block, err)
+		return err
+	}
+
+	chain.LastHash = block.Hash
+
+	chain.Logger.Infow("block_connected",
+		"hash", block.GetHash(),
+		"height", block.Height,
+	)
+
+	return nil
+}
+
+// disconnectTip moves the active tip back to its parent, reverting the
+// block's UTXO set changes in the same database transaction.
+func (chain *Blockchain) disconnectTip() (*Block, error) {
+	tip, err := chain.GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tip.PrevHash) == 0 {
+		return nil, errors.New("cannot disconnect genesis block")
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		if err := deleteHeightIndex(txn, tip); err != nil {
+			return err
+		}
+		if err := (UTXOSet{Blockchain: chain}).disconnectBlock(txn, tip); err != nil {
+			return err
+		}
+		if chain.txIndex {
+			if err := unindexBlockTransactions(txn, tip); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte("lh"), tip.PrevHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chain.LastHash = tip.PrevHash
+
+	chain.Logger.Infow("block_disconnected",
+		"hash", tip.GetHash(),
+		"height", tip.Height,
+	)
+
+	return tip, nil
+}
+
+func invalidKey(hash []byte) []byte {
+	return append(append([]byte{}, invalidPrefix...), hash...)
+}
+
+// markInvalid excludes the block and every block built on it from fork choice.
+func (chain *Blockchain) markInvalid(hash []byte) {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(invalidKey(hash), []byte{1})
+	})
+	if err != nil {
+		chain.Logger.Errorw("marking_block_invalid_failed",
+			"hash", fmt.Sprintf("%x", hash),
+			"error", err,
+		)
+	}
+}
+
+// InvalidateBlock excludes hash and its descendants from fork choice. It
+// doesn't disconnect the block if it is part of the active chain.
+func (chain *Blockchain) InvalidateBlock(hash []byte) {
+	chain.Logger.Warnw("block_invalidated",
+		"hash", fmt.Sprintf("%x", hash),
+	)
+
+	chain.markInvalid(hash)
+}
+
+func (chain *Blockchain) isInvalid(hash []byte) bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(invalidKey(hash))
+		return err
+	})
+
+	return err == nil
+}
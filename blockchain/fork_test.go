@@ -0,0 +1,170 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+// mineTestBranchBlock mines a block paying miner on top of parent, which
+// doesn't have to be the tip, and adds it to the chain. The transactions
+// have to pay no fee.
+func mineTestBranchBlock(t *testing.T, chain *Blockchain, parent *Block, miner *wallet.Wallet, txs ...*Transaction) (*Block, *ChainUpdate, error) {
+	t.Helper()
+
+	subsidy := chain.Policy.Subsidy(parent.Height + 1)
+	txs = append(txs, CoinbaseTx(string(miner.Address()), "", subsidy))
+
+	timestamp, err := chain.NextBlockTime(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := newBlock(txs, parent.Hash, parent.Height+1, timestamp)
+	if err := chain.Consensus.Prepare(chain, block, parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Consensus.Seal(context.Background(), chain, block); err != nil {
+		t.Fatal(err)
+	}
+
+	update, err := chain.AddBlock(block)
+
+	return block, update, err
+}
+
+func blockHashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash)
+	}
+
+	return hashes
+}
+
+func TestReorganizeToHeavierBranch(t *testing.T) {
+	chain, alice := newTestChain(t, "1")
+	bob, carol := wallet.MakeWallet(), wallet.MakeWallet()
+
+	genesis, err := chain.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceGenesis := balance(t, chain, alice)
+
+	tx := NewTransaction(alice, string(bob.Address()), 20, 0, &UTXOSet{Blockchain: chain})
+	a1 := mineTestBlock(t, chain, alice, tx)
+
+	if got := balance(t, chain, bob); got != 20 {
+		t.Fatalf("bob has %d, want 20", got)
+	}
+
+	// A branch with as much work as the active one doesn't replace it.
+	b1, update, err := mineTestBranchBlock(t, chain, genesis, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.IsEmpty() || !bytes.Equal(chain.LastHash, a1.Hash) {
+		t.Fatalf("tip moved to %x on a branch with equal work", chain.LastHash)
+	}
+
+	b2, update, err := mineTestBranchBlock(t, chain, b1, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, b2.Hash) {
+		t.Fatalf("tip is %x, want the heavier branch tip %x", chain.LastHash, b2.Hash)
+	}
+	if got, want := blockHashes(update.Disconnected), [][]byte{a1.Hash}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("disconnected %x, want %x", got, want)
+	}
+	if got, want := blockHashes(update.Connected), [][]byte{b1.Hash, b2.Hash}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("connected %x, want %x", got, want)
+	}
+
+	// The payment and the reward of the old branch are rolled back.
+	if got := balance(t, chain, bob); got != 0 {
+		t.Fatalf("bob has %d after the reorganization, want 0", got)
+	}
+	if got := balance(t, chain, alice); got != aliceGenesis {
+		t.Fatalf("alice has %d after the reorganization, want %d", got, aliceGenesis)
+	}
+	if got, want := balance(t, chain, carol), 2*chain.Policy.Subsidy(1); got != want {
+		t.Fatalf("carol has %d, want %d", got, want)
+	}
+
+	if block, err := chain.GetBlockByHeight(1); err != nil || !bytes.Equal(block.Hash, b1.Hash) {
+		t.Fatalf("height 1 indexes %v, want %x: %v", block, b1.Hash, err)
+	}
+}
+
+func TestReorganizeRejectsDoubleSpendBranch(t *testing.T) {
+	chain, alice := newTestChain(t, "1")
+	bob, carol := wallet.MakeWallet(), wallet.MakeWallet()
+
+	genesis, err := chain.GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both transactions spend the genesis reward, the only output alice
+	// has yet.
+	UTXOSet := UTXOSet{Blockchain: chain}
+	pay := NewTransaction(alice, string(bob.Address()), 20, 0, &UTXOSet)
+	doubleSpend := NewTransaction(alice, string(carol.Address()), 30, 0, &UTXOSet)
+
+	a1 := mineTestBlock(t, chain, alice)
+	a2 := mineTestBlock(t, chain, alice)
+	aliceBalance := balance(t, chain, alice)
+
+	c1, _, err := mineTestBranchBlock(t, chain, genesis, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _, err := mineTestBranchBlock(t, chain, c1, carol, pay, doubleSpend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c3 gives the branch more work, so it is connected and c2 fails.
+	c3, update, err := mineTestBranchBlock(t, chain, c2, carol)
+	if reason, _ := RejectReasonOf(err); reason != RejectDoubleSpend {
+		t.Fatalf("got %v, want %s", err, RejectDoubleSpend)
+	}
+	if !update.IsEmpty() {
+		t.Fatalf("failed reorganization reported %d disconnected and %d connected blocks", len(update.Disconnected), len(update.Connected))
+	}
+
+	if !bytes.Equal(chain.LastHash, a2.Hash) {
+		t.Fatalf("tip is %x, want the restored tip %x", chain.LastHash, a2.Hash)
+	}
+	if block, err := chain.GetBlockByHeight(1); err != nil || !bytes.Equal(block.Hash, a1.Hash) {
+		t.Fatalf("height 1 indexes %v, want %x: %v", block, a1.Hash, err)
+	}
+	if got := balance(t, chain, alice); got != aliceBalance {
+		t.Fatalf("alice has %d after the failed reorganization, want %d", got, aliceBalance)
+	}
+	if got := balance(t, chain, carol); got != 0 {
+		t.Fatalf("carol has %d after the failed reorganization, want 0", got)
+	}
+
+	if chain.isInvalid(c1.Hash) {
+		t.Fatal("valid block before the double spend marked invalid")
+	}
+	for _, block := range []*Block{c2, c3} {
+		if !chain.isInvalid(block.Hash) {
+			t.Fatalf("block %s at height %d not marked invalid", block.GetHash(), block.Height)
+		}
+	}
+
+	if _, _, err := mineTestBranchBlock(t, chain, c3, carol); !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("block on the invalid branch: got %v, want %v", err, ErrInvalidChain)
+	}
+
+	// The active chain still grows.
+	mineTestBlock(t, chain, alice)
+}
@@ -0,0 +1,164 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// BlockVersion is the version new blocks have to use. Version 2 blocks
+// commit to a merkle root of transaction IDs. Version 1 blocks hashed the
+// same header but built the merkle root from JSON encoded transactions, and
+// version 0 blocks, stored by older builds, have a hash that only covers the
+// previous hash, transactions, nonce and the fixed difficulty of 18 they
+// were mined with.
+const BlockVersion = 2
+
+// headerSize is the length of a serialized header without a signer.
+const headerSize = 96
+
+// BlockHeader holds every field a block hash commits to.
+type BlockHeader struct {
+	Version    int32
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Bits       uint32
+	Nonce      int64
+	Height     int64
+	// Signer is the validator key of proof of authority blocks, so that
+	// two validators sealing the same block make blocks with different
+	// hashes. It is empty for other blocks.
+	Signer []byte
+}
+
+// Header returns the header of the block.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Version:    b.Version,
+		PrevHash:   b.PrevHash,
+		MerkleRoot: b.MerkleRoot,
+		Timestamp:  b.Timestamp,
+		Bits:       b.Bits,
+		Nonce:      int64(b.Nonce),
+		Height:     int64(b.Height),
+		Signer:     b.sealSigner(),
+	}
+}
+
+// sealSigner returns the validator key a seal starts with.
+func (b *Block) sealSigner() []byte {
+	if len(b.Seal) < ed25519.PublicKeySize {
+		return nil
+	}
+
+	return b.Seal[:ed25519.PublicKeySize]
+}
+
+// Serialize encodes the header in the fixed 96 byte layout that is hashed:
+// version, previous hash, merkle root, timestamp, bits, nonce and height,
+// followed by the 32 byte signer of proof of authority blocks. Integers are
+// big endian and the hashes are padded to 32 bytes, which leaves the
+// previous hash of the genesis block all zeros.
+func (h BlockHeader) Serialize() []byte {
+	data := make([]byte, 0, headerSize+len(h.Signer))
+
+	data = binary.BigEndian.AppendUint32(data, uint32(h.Version))
+	data = append(data, padHash(h.PrevHash)...)
+	data = append(data, padHash(h.MerkleRoot)...)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Timestamp))
+	data = binary.BigEndian.AppendUint32(data, h.Bits)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Nonce))
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Height))
+	data = append(data, h.Signer...)
+
+	return data
+}
+
+// DeserializeBlockHeader parses a header serialized by Serialize.
+func DeserializeBlockHeader(data []byte) (BlockHeader, error) {
+	if len(data) != headerSize && len(data) != headerSize+ed25519.PublicKeySize {
+		return BlockHeader{}, fmt.Errorf("block header has %d bytes, expected %d or %d", len(data), headerSize, headerSize+ed25519.PublicKeySize)
+	}
+
+	header := BlockHeader{
+		Version:    int32(binary.BigEndian.Uint32(data[0:4])),
+		PrevHash:   data[4:36],
+		MerkleRoot: data[36:68],
+		Timestamp:  int64(binary.BigEndian.Uint64(data[68:76])),
+		Bits:       binary.BigEndian.Uint32(data[76:80]),
+		Nonce:      int64(binary.BigEndian.Uint64(data[80:88])),
+		Height:     int64(binary.BigEndian.Uint64(data[88:96])),
+	}
+	if len(data) > headerSize {
+		header.Signer = data[headerSize:]
+	}
+
+	return header, nil
+}
+
+// CheckProofOfWork reports whether the header hash meets its own target.
+// It doesn't tell whether the target is the one the chain requires.
+func (h BlockHeader) CheckProofOfWork() bool {
+	return new(big.Int).SetBytes(h.Hash()).Cmp(CompactToBig(h.Bits)) < 0
+}
+
+// Hash is the block hash the header identifies.
+func (h BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+func padHash(hash []byte) []byte {
+	padded := make([]byte, sha256.Size)
+	copy(padded, hash)
+
+	return padded
+}
+
+// ComputeHash recomputes the block hash from the header, or from the legacy
+// proof of work data for blocks stored before headers were versioned.
+func (b *Block) ComputeHash() []byte {
+	hash := sha256.Sum256(NewProof(b).InitData(b.Nonce))
+
+	return hash[:]
+}
+
+// checkHeaderCommitments makes sure the block hash, merkle root and
+// transaction IDs are the ones its header and transactions produce. A block
+// failing it wasn't necessarily mined that way, so it is never marked
+// invalid.
+func checkHeaderCommitments(block *Block) error {
+	if block.Version < BlockVersion {
+		return ruleError(RejectBadVersion, block.GetHash(), "block version %d is below %d", block.Version, BlockVersion)
+	}
+
+	// The merkle tree pairs the last node of an odd level with itself, so
+	// repeating transactions can give a different block the same root.
+	seen := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		if id := tx.Hash(); !bytes.Equal(tx.ID, id) {
+			return ruleError(RejectBadTxID, tx.GetID(), "id doesnt match transaction hash %x", id)
+		}
+
+		if seen[string(tx.ID)] {
+			return ruleError(RejectDuplicateTx, block.GetHash(), "transaction %s is included twice", tx.GetID())
+		}
+		seen[string(tx.ID)] = true
+	}
+
+	if root := block.HashTransactions(); !bytes.Equal(block.MerkleRoot, root) {
+		return ruleError(RejectBadMerkleRoot, block.GetHash(), "merkle root %x doesnt match transactions root %x", block.MerkleRoot, root)
+	}
+
+	if hash := block.ComputeHash(); !bytes.Equal(block.Hash, hash) {
+		return ruleError(RejectBadHash, block.GetHash(), "hash doesnt match header hash %x", hash)
+	}
+
+	return nil
+}
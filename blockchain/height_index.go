@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	heightPrefix = []byte("height-")
+)
+
+// heightKey maps an active chain height to its block hash. Heights are
+// stored big endian so that keys iterate in height order.
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], uint64(height))
+
+	return key
+}
+
+func setHeightIndex(txn *badger.Txn, block *Block) error {
+	return txn.Set(heightKey(block.Height), block.Hash)
+}
+
+func deleteHeightIndex(txn *badger.Txn, block *Block) error {
+	return txn.Delete(heightKey(block.Height))
+}
+
+// GetBlockHashByHeight returns the hash of the active chain block at height.
+func (chain *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightKey(height))
+		if err != nil {
+			return err
+		}
+
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("block at height - %d not found", height)
+	}
+
+	return hash, nil
+}
+
+// GetBlocksByHeightRange returns active chain blocks from height `from` to
+// `to` inclusive, ordered by height. Heights above the tip are ignored.
+func (chain *Blockchain) GetBlocksByHeightRange(from, to int) ([]*Block, error) {
+	if from < 0 || to < from {
+		return nil, fmt.Errorf("invalid height range %d-%d", from, to)
+	}
+
+	var blocks []*Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		end := heightKey(to)
+
+		for it.Seek(heightKey(from)); it.ValidForPrefix(heightPrefix); it.Next() {
+			if string(it.Item().Key()) > string(end) {
+				break
+			}
+
+			hash, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			item, err := txn.Get(hash)
+			if err != nil {
+				return fmt.Errorf("indexed block %x not found: %w", hash, err)
+			}
+
+			blockData, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			blocks = append(blocks, Deserialize(blockData))
+		}
+
+		return nil
+	})
+
+	return blocks, err
+}
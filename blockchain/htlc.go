@@ -0,0 +1,305 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+// HTLCSecretSize is the size of hash time locked contract secrets. Fixing it
+// keeps a secret that is too big for one chain from being revealed on the
+// other.
+const HTLCSecretSize = 32
+
+var (
+	ErrSecretNotFound = errors.New("secret not revealed on chain")
+	ErrNotHTLC        = errors.New("output is not a hash time locked contract")
+)
+
+// HTLC is a hash time locked contract: the recipient can spend the output
+// with the secret hashing to SecretHash, and once LockTime has passed the
+// refund key can take it back. LockTime works like Transaction.LockTime.
+type HTLC struct {
+	SecretHash []byte
+	Recipient  []byte
+	Refund     []byte
+	LockTime   uint32
+}
+
+// NewHTLCScript returns the locking script of h:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_SHA256 <recipient>
+//	OP_ELSE
+//	    <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_SHA256 <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func NewHTLCScript(h HTLC) []byte {
+	return NewScriptBuilder().
+		AddOp(OpIf).
+		AddOp(OpSize).AddInt64(HTLCSecretSize).AddOp(OpEqualVerify).
+		AddOp(OpSha256).AddData(h.SecretHash).AddOp(OpEqualVerify).
+		AddOp(OpDup).AddOp(OpSha256).AddData(h.Recipient).
+		AddOp(OpElse).
+		AddInt64(int64(h.LockTime)).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop).
+		AddOp(OpDup).AddOp(OpSha256).AddData(h.Refund).
+		AddOp(OpEndIf).
+		AddOp(OpEqualVerify).AddOp(OpCheckSig).
+		Script()
+}
+
+// ExtractHTLC returns the contract of a script built by NewHTLCScript.
+func ExtractHTLC(script []byte) (HTLC, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 20 {
+		return HTLC{}, false
+	}
+
+	lockTime, ok := pushedNum(ops[11])
+	if !ok || lockTime < 0 || lockTime > int64(MaxSequence) {
+		return HTLC{}, false
+	}
+
+	h := HTLC{
+		SecretHash: ops[5].data,
+		Recipient:  ops[9].data,
+		Refund:     ops[16].data,
+		LockTime:   uint32(lockTime),
+	}
+
+	// Rebuilding the script checks the opcodes and hash sizes in one go.
+	if len(h.SecretHash) != sha256.Size || len(h.Recipient) != sha256.Size || len(h.Refund) != sha256.Size ||
+		!bytes.Equal(NewHTLCScript(h), script) {
+		return HTLC{}, false
+	}
+
+	return h, true
+}
+
+// HTLCClaimScript returns the script spending a contract output with the
+// secret: <signature> <pubKey> <secret> OP_1.
+func HTLCClaimScript(signature, pubKey, secret []byte) []byte {
+	return NewScriptBuilder().
+		AddData(signature).
+		AddData(pubKey).
+		AddData(secret).
+		AddInt64(1).
+		Script()
+}
+
+// HTLCRefundScript returns the script spending an expired contract output:
+// <signature> <pubKey> OP_0.
+func HTLCRefundScript(signature, pubKey []byte) []byte {
+	return NewScriptBuilder().
+		AddData(signature).
+		AddData(pubKey).
+		AddInt64(0).
+		Script()
+}
+
+// FindHTLCs returns the contract outputs pubKeyHash can claim or refund.
+func (u UTXOSet) FindHTLCs(pubKeyHash []byte) []UTXO {
+	var utxos []UTXO
+
+	u.forEach(func(utxo UTXO) {
+		h, ok := ExtractHTLC(utxo.Output.LockingScript())
+		if ok && (bytes.Equal(h.Recipient, pubKeyHash) || bytes.Equal(h.Refund, pubKeyHash)) {
+			utxos = append(utxos, utxo)
+		}
+	})
+
+	return utxos
+}
+
+// NewHTLCClaimTransaction creates a signed transaction sending every
+// contract output the wallet can claim with secret to an address, minus
+// fee.
+func NewHTLCClaimTransaction(w *wallet.Wallet, secret []byte, to string, fee int, UTXO *UTXOSet) *Transaction {
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKeyBytes())
+	secretHash := sha256.Sum256(secret)
+
+	tx := &Transaction{Timestamp: time.Now().UnixNano(), Version: TxVersion}
+	prevOuts := make(map[string]TxOutput)
+
+	for _, utxo := range UTXO.FindHTLCs(pubKeyHash) {
+		h, _ := ExtractHTLC(utxo.Output.LockingScript())
+		if !bytes.Equal(h.Recipient, pubKeyHash) || !bytes.Equal(h.SecretHash, secretHash[:]) {
+			continue
+		}
+
+		tx.Inputs = append(tx.Inputs, TxInput{ID: utxo.Outpoint.ID, Out: utxo.Outpoint.Index})
+		prevOuts[utxo.Outpoint.String()] = utxo.Output
+	}
+
+	signSweep(tx, w.PrivateKey, prevOuts, to, fee, UTXO.Blockchain.ChainID(), func(signature, pubKey []byte) []byte {
+		return HTLCClaimScript(signature, pubKey, secret)
+	})
+
+	return tx
+}
+
+// NewHTLCRefundTransaction creates a signed transaction sending every
+// expired contract output the wallet funded back to an address, minus fee.
+// Like NewClaimTransaction it refunds contracts expiring at a time only
+// once none expiring at a height are left.
+func NewHTLCRefundTransaction(w *wallet.Wallet, to string, fee int, UTXO *UTXOSet) *Transaction {
+	chain := UTXO.Blockchain
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKeyBytes())
+
+	tip, err := chain.GetLastBlock()
+	Handle(err)
+
+	spendHeight := tip.Height + 1
+	spendTime, err := chain.MedianTimePast(tip)
+	Handle(err)
+
+	var byHeight, byTime []TxInput
+	outs := make(map[string]TxOutput)
+
+	for _, utxo := range UTXO.FindHTLCs(pubKeyHash) {
+		h, _ := ExtractHTLC(utxo.Output.LockingScript())
+		if !bytes.Equal(h.Refund, pubKeyHash) {
+			continue
+		}
+
+		in := TxInput{ID: utxo.Outpoint.ID, Out: utxo.Outpoint.Index}
+		if h.LockTime < LockTimeThreshold {
+			if int(h.LockTime) < spendHeight {
+				byHeight = append(byHeight, in)
+			}
+		} else if int64(h.LockTime) < spendTime {
+			byTime = append(byTime, in)
+		}
+
+		outs[utxo.Outpoint.String()] = utxo.Output
+	}
+
+	tx := &Transaction{
+		Inputs:    byHeight,
+		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
+		LockTime:  uint32(spendHeight - 1),
+	}
+	if len(byHeight) == 0 {
+		tx.Inputs = byTime
+		tx.LockTime = uint32(spendTime - 1)
+	}
+
+	prevOuts := make(map[string]TxOutput)
+	for _, in := range tx.Inputs {
+		outpoint := Outpoint{ID: in.ID, Index: in.Out}.String()
+		prevOuts[outpoint] = outs[outpoint]
+	}
+
+	signSweep(tx, w.PrivateKey, prevOuts, to, fee, chain.ChainID(), HTLCRefundScript)
+
+	return tx
+}
+
+// signSweep pays the outputs tx spends to an address, minus fee, and signs
+// every input with the unlocking script built by unlock.
+func signSweep(tx *Transaction, privKey ed25519.PrivateKey, prevOuts map[string]TxOutput, to string, fee int, chainID []byte, unlock func(signature, pubKey []byte) []byte) {
+	if len(tx.Inputs) == 0 {
+		log.Panic("Error: no contracts to spend")
+	}
+
+	total := 0
+	for _, out := range prevOuts {
+		total += out.Value
+	}
+
+	if fee < 0 || total <= fee {
+		log.Panic("Error: contract funds dont cover the fee")
+	}
+
+	tx.Outputs = []TxOutput{*NewTXOutput(total-fee, to)}
+
+	pubKey := []byte(privKey.Public().(ed25519.PublicKey))
+	for idx := range tx.Inputs {
+		signature, err := tx.SignatureForInput(idx, privKey, prevOuts, SigHashAll, chainID)
+		Handle(err)
+
+		tx.Inputs[idx].ScriptSig = unlock(signature, pubKey)
+	}
+
+	tx.ID = tx.Hash()
+}
+
+// FindHTLCSecret finds the secret revealed by the claim of contract, the
+// outpoint of a contract the caller funded. This is how the other side of a
+// swap learns the secret it needs to claim its own contract. Only the input
+// spending contract is looked at: anyone can fund more contracts locked to
+// the same secret hash, so a search by hash could be made to miss the claim.
+// The funding block is found through the transaction index when it is
+// enabled, the claim by walking the active chain from there up to the tip.
+func (chain *Blockchain) FindHTLCSecret(contract Outpoint) ([]byte, error) {
+	funding, err := chain.LookupTransaction(contract.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := funding.Transaction.Outputs
+	if contract.Index < 0 || contract.Index >= len(outputs) {
+		return nil, fmt.Errorf("%w: %s", ErrNotHTLC, contract)
+	}
+
+	h, ok := ExtractHTLC(outputs[contract.Index].LockingScript())
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotHTLC, contract)
+	}
+
+	if _, err := (UTXOSet{Blockchain: chain}).GetUTXO(contract); err == nil {
+		return nil, ErrSecretNotFound
+	}
+
+	tip := chain.GetBestHeight()
+
+	for height := funding.Block.Height; height <= tip; height++ {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range block.Transactions {
+			for _, in := range tx.Inputs {
+				if !bytes.Equal(in.ID, contract.ID) || in.Out != contract.Index {
+					continue
+				}
+
+				if secret, ok := revealedSecret(in, h.SecretHash); ok {
+					return secret, nil
+				}
+
+				return nil, fmt.Errorf("%w: %s was refunded", ErrSecretNotFound, contract)
+			}
+		}
+	}
+
+	return nil, ErrSecretNotFound
+}
+
+// revealedSecret returns the data the unlocking script of in pushes that
+// hashes to secretHash.
+func revealedSecret(in TxInput, secretHash []byte) ([]byte, bool) {
+	ops, err := parseScript(in.ScriptSig)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, op := range ops {
+		hash := sha256.Sum256(op.data)
+		if len(op.data) == HTLCSecretSize && bytes.Equal(hash[:], secretHash) {
+			return op.data, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+// newTestChain creates a regtest chain kept in a temporary directory whose
+// genesis reward pays a new wallet.
+func newTestChain(t *testing.T, nodeID string) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+
+	if err := params.Select(params.RegTest.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := params.RegTest.DataDir
+	params.RegTest.DataDir = t.TempDir()
+	t.Cleanup(func() {
+		params.RegTest.DataDir = dataDir
+		params.Select(params.MainNet.Name)
+	})
+
+	w := wallet.MakeWallet()
+	chain := InitBlockchain(string(w.Address()), nodeID, NetworkMonetaryPolicy(params.Active()), DefaultConsensusConfig)
+	t.Cleanup(func() { chain.Database.Close() })
+
+	return chain, w
+}
+
+// mineTestBlock mines txs into a block on top of the tip paying miner, and
+// connects it.
+func mineTestBlock(t *testing.T, chain *Blockchain, miner *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+
+	fees := 0
+	for _, tx := range txs {
+		fee, err := chain.TransactionFee(tx)
+		if err != nil {
+			t.Fatalf("transaction %s: %v", tx.GetID(), err)
+		}
+		fees += fee
+	}
+
+	subsidy := chain.Policy.Subsidy(chain.GetBestHeight() + 1)
+	txs = append(txs, CoinbaseTx(string(miner.Address()), "", subsidy+fees))
+
+	block, err := chain.MineBlock(context.Background(), txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, block.Hash) {
+		t.Fatalf("block %s didnt become the tip", block.GetHash())
+	}
+
+	return block
+}
+
+func balance(t *testing.T, chain *Blockchain, w *wallet.Wallet) int {
+	t.Helper()
+
+	script, err := AddressScript(string(w.Address()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spendable, _ := UTXOSet{Blockchain: chain}.Balance(script)
+
+	return spendable
+}
+
+// fundTestHTLC mines a contract paying to, refundable to from, and returns
+// its outpoint.
+func fundTestHTLC(t *testing.T, chain *Blockchain, from, to *wallet.Wallet, secretHash []byte, lockBlocks, amount int) Outpoint {
+	t.Helper()
+
+	contract := HTLC{
+		SecretHash: secretHash,
+		Recipient:  wallet.PublicKeyHash(to.PublicKeyBytes()),
+		Refund:     wallet.PublicKeyHash(from.PublicKeyBytes()),
+		LockTime:   uint32(chain.GetBestHeight() + lockBlocks),
+	}
+
+	tx := NewScriptTransaction(from, NewHTLCScript(contract), amount, 0, &UTXOSet{Blockchain: chain})
+	mineTestBlock(t, chain, from, tx)
+
+	for idx, out := range tx.Outputs {
+		if _, ok := ExtractHTLC(out.LockingScript()); ok {
+			return Outpoint{ID: tx.ID, Index: idx}
+		}
+	}
+	t.Fatalf("transaction %s funds no contract", tx.GetID())
+
+	return Outpoint{}
+}
+
+func TestHTLCSwap(t *testing.T) {
+	chain1, alice := newTestChain(t, "1")
+	chain2, bob := newTestChain(t, "2")
+
+	secret := make([]byte, HTLCSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(secret)
+
+	fundTestHTLC(t, chain1, alice, bob, hash[:], 20, 10)
+	bobContract := fundTestHTLC(t, chain2, bob, alice, hash[:], 10, 8)
+
+	if _, err := chain2.FindHTLCSecret(bobContract); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("secret found before the claim: %v", err)
+	}
+
+	aliceBefore := balance(t, chain2, alice)
+	claim := NewHTLCClaimTransaction(alice, secret, string(alice.Address()), 0, &UTXOSet{Blockchain: chain2})
+	mineTestBlock(t, chain2, bob, claim)
+
+	if got := balance(t, chain2, alice) - aliceBefore; got != 8 {
+		t.Fatalf("alice claimed %d on chain 2, want 8", got)
+	}
+
+	// A contract with the same secret hash funded after the claim doesn't
+	// hide the secret from Bob.
+	fundTestHTLC(t, chain2, alice, alice, hash[:], 10, 1)
+
+	revealed, err := chain2.FindHTLCSecret(bobContract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(revealed, secret) {
+		t.Fatalf("found secret %x, want %x", revealed, secret)
+	}
+
+	chain2.ReindexTransactions()
+	if indexed, err := chain2.FindHTLCSecret(bobContract); err != nil || !bytes.Equal(indexed, secret) {
+		t.Fatalf("found secret %x with the transaction index: %v", indexed, err)
+	}
+
+	bobBefore := balance(t, chain1, bob)
+	claim = NewHTLCClaimTransaction(bob, revealed, string(bob.Address()), 0, &UTXOSet{Blockchain: chain1})
+	mineTestBlock(t, chain1, alice, claim)
+
+	if got := balance(t, chain1, bob) - bobBefore; got != 10 {
+		t.Fatalf("bob claimed %d on chain 1, want 10", got)
+	}
+}
+
+func TestHTLCRefundAfterTimeout(t *testing.T) {
+	chain, alice := newTestChain(t, "1")
+	bob := wallet.MakeWallet()
+
+	hash := sha256.Sum256([]byte("secret bob never learns"))
+	fundTestHTLC(t, chain, alice, bob, hash[:], 3, 10)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	contracts := UTXOSet.FindHTLCs(wallet.PublicKeyHash(alice.PublicKeyBytes()))
+	if len(contracts) != 1 {
+		t.Fatalf("found %d contracts, want 1", len(contracts))
+	}
+	contract := contracts[0]
+	htlc, _ := ExtractHTLC(contract.Output.LockingScript())
+
+	// A refund whose lock time is before the contract lock fails
+	// OP_CHECKLOCKTIMEVERIFY.
+	early := &Transaction{
+		Inputs:   []TxInput{{ID: contract.Outpoint.ID, Out: contract.Outpoint.Index}},
+		Version:  TxVersion,
+		LockTime: uint32(chain.GetBestHeight()),
+	}
+	prevOuts := map[string]TxOutput{contract.Outpoint.String(): contract.Output}
+	signSweep(early, alice.PrivateKey, prevOuts, string(alice.Address()), 0, chain.ChainID(), HTLCRefundScript)
+
+	err := chain.CheckTransaction(early)
+	if reason, _ := RejectReasonOf(err); reason != RejectScriptFailed {
+		t.Fatalf("early refund: got %v, want %s", err, RejectScriptFailed)
+	}
+
+	for chain.GetBestHeight() < int(htlc.LockTime) {
+		mineTestBlock(t, chain, bob)
+	}
+
+	before := balance(t, chain, alice)
+	refund := NewHTLCRefundTransaction(alice, string(alice.Address()), 0, &UTXOSet)
+	mineTestBlock(t, chain, bob, refund)
+
+	if got := balance(t, chain, alice) - before; got != 10 {
+		t.Fatalf("alice got %d back, want 10", got)
+	}
+	if len(UTXOSet.FindHTLCs(wallet.PublicKeyHash(alice.PublicKeyBytes()))) != 0 {
+		t.Fatal("contract still unspent after the refund")
+	}
+}
@@ -0,0 +1,477 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxScriptSize is the maximum length of a script in bytes.
+	MaxScriptSize = 10000
+	// MaxScriptElementSize is the maximum size of a stack element.
+	MaxScriptElementSize = 520
+	// MaxScriptOps is the maximum number of non push opcodes a script may
+	// execute.
+	MaxScriptOps = 201
+	// MaxStackSize is the maximum number of elements on the stack.
+	MaxStackSize = 1000
+	// MaxMultisigKeys is the maximum number of keys of OP_CHECKMULTISIG.
+	// Redeem scripts are pushed like any data, so 15 keys of 32 bytes is as
+	// many as fit into MaxScriptElementSize.
+	MaxMultisigKeys = 15
+)
+
+var (
+	ErrScriptFalse      = errors.New("script evaluated to false")
+	ErrScriptNotPush    = errors.New("unlocking script is not push only")
+	ErrScriptLimit      = errors.New("script limit exceeded")
+	ErrStackUnderflow   = errors.New("stack underflow")
+	ErrUnbalancedIf     = errors.New("unbalanced conditional")
+	ErrVerifyFailed     = errors.New("verify failed")
+	ErrOpReturn         = errors.New("script returned early")
+	ErrUnknownOpcode    = errors.New("unknown opcode")
+	ErrMissingPrevOut   = errors.New("spent output not found")
+	errStackElementSize = fmt.Errorf("%w: stack element larger than %d bytes", ErrScriptLimit, MaxScriptElementSize)
+)
+
+// scriptEngine runs the scripts of one input of a transaction.
+type scriptEngine struct {
+	tx       *Transaction
+	idx      int
+	prevOuts map[string]TxOutput
+	chainID  []byte
+
+	stack   [][]byte
+	opCount int
+}
+
+// VerifyScript runs the unlocking script of input idx of tx and then the
+// locking script of the output it spends on the resulting stack. The input
+// is valid if that leaves a true value on top of the stack. prevOuts holds
+// the outputs spent by the inputs, keyed by Outpoint.String(), and chainID
+// is the genesis block hash; both are needed to check signatures.
+//
+// If the locking script is a pay to script hash script, the last push of
+// the unlocking script is the redeem script. After the hash matched, the
+// redeem script is run on the remaining pushes and has to succeed as well.
+func VerifyScript(tx *Transaction, idx int, prevOuts map[string]TxOutput, chainID []byte) error {
+	in := tx.Inputs[idx]
+	outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+	prevOut, ok := prevOuts[outpoint.String()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingPrevOut, outpoint)
+	}
+
+	// Unlocking scripts can't contain logic of their own, otherwise they
+	// could skip the checks of the locking script.
+	if !IsPushOnly(in.ScriptSig) {
+		return ErrScriptNotPush
+	}
+
+	e := &scriptEngine{
+		tx:       tx,
+		idx:      idx,
+		prevOuts: prevOuts,
+		chainID:  chainID,
+	}
+
+	if err := e.execute(in.ScriptSig); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	pushed := append([][]byte{}, e.stack...)
+
+	lockingScript := prevOut.LockingScript()
+	if err := e.run(lockingScript); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	if _, ok := ExtractScriptHash(lockingScript); !ok {
+		return nil
+	}
+
+	e.stack = pushed[:len(pushed)-1]
+	if err := e.run(pushed[len(pushed)-1]); err != nil {
+		return fmt.Errorf("redeem script: %w", err)
+	}
+
+	return nil
+}
+
+// run executes script and checks that it left a true value on the stack.
+func (e *scriptEngine) run(script []byte) error {
+	e.opCount = 0
+
+	if err := e.execute(script); err != nil {
+		return err
+	}
+
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFalse
+	}
+
+	return nil
+}
+
+func (e *scriptEngine) execute(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("%w: script has %d bytes, max %d", ErrScriptLimit, len(script), MaxScriptSize)
+	}
+
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	// conditions holds whether each enclosing OP_IF branch is taken.
+	var conditions []bool
+
+	for _, op := range ops {
+		if len(op.data) > MaxScriptElementSize {
+			return errStackElementSize
+		}
+
+		if !op.isPush() {
+			if err := e.countOps(1); err != nil {
+				return err
+			}
+		}
+
+		executing := true
+		for _, taken := range conditions {
+			executing = executing && taken
+		}
+
+		switch op.code {
+		case OpIf, OpNotIf:
+			taken := false
+			if executing {
+				v, err := e.pop()
+				if err != nil {
+					return err
+				}
+				taken = asBool(v) == (op.code == OpIf)
+			}
+			conditions = append(conditions, taken)
+			continue
+
+		case OpElse:
+			if len(conditions) == 0 {
+				return ErrUnbalancedIf
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+			continue
+
+		case OpEndIf:
+			if len(conditions) == 0 {
+				return ErrUnbalancedIf
+			}
+			conditions = conditions[:len(conditions)-1]
+			continue
+		}
+
+		if !executing {
+			continue
+		}
+
+		if err := e.step(op); err != nil {
+			return err
+		}
+
+		if len(e.stack) > MaxStackSize {
+			return fmt.Errorf("%w: more than %d stack elements", ErrScriptLimit, MaxStackSize)
+		}
+	}
+
+	if len(conditions) != 0 {
+		return ErrUnbalancedIf
+	}
+
+	return nil
+}
+
+// step executes an opcode other than the flow control ones.
+func (e *scriptEngine) step(op scriptOp) error {
+	switch {
+	case op.code <= OpPushData2:
+		e.push(op.data)
+		return nil
+	case op.code == Op1Negate:
+		e.push(encodeScriptNum(-1))
+		return nil
+	case op.code >= Op1 && op.code <= Op16:
+		e.push(encodeScriptNum(int64(op.code - Op1 + 1)))
+		return nil
+	}
+
+	switch op.code {
+	case OpNop:
+
+	case OpVerify:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(v) {
+			return ErrVerifyFailed
+		}
+
+	case OpReturn:
+		return ErrOpReturn
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+
+	case OpDup:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(v)
+
+	case OpSwap:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(a)
+		e.push(b)
+
+	case OpSize:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(encodeScriptNum(int64(len(v))))
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+
+		equal := bytes.Equal(a, b)
+		if op.code == OpEqualVerify {
+			if !equal {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		e.pushBool(equal)
+
+	case OpSha256:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(v)
+		e.push(hash[:])
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+
+		valid := e.checkSig(signature, pubKey)
+		if op.code == OpCheckSigVerify {
+			if !valid {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		e.pushBool(valid)
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		valid, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+
+		if op.code == OpCheckMultiSigVerify {
+			if !valid {
+				return ErrVerifyFailed
+			}
+			return nil
+		}
+		e.pushBool(valid)
+
+	case OpCheckLockTimeVerify, OpCheckSequenceVerify:
+		// The lock stays on the stack, scripts drop it themselves.
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+
+		// Lock times use up to 5 bytes, as 4 byte script numbers can't
+		// reach 2^31 and beyond.
+		lock, err := decodeScriptNum(v, 5)
+		if err != nil {
+			return err
+		}
+		if lock < 0 {
+			return fmt.Errorf("%w: negative lock %d", ErrUnsatisfiedLock, lock)
+		}
+
+		if op.code == OpCheckLockTimeVerify {
+			return e.checkLockTime(lock)
+		}
+		return e.checkSequence(lock)
+
+	default:
+		return fmt.Errorf("%w %#x", ErrUnknownOpcode, op.code)
+	}
+
+	return nil
+}
+
+// countOps adds n executed opcodes to the limit of the running script.
+func (e *scriptEngine) countOps(n int) error {
+	e.opCount += n
+	if e.opCount > MaxScriptOps {
+		return fmt.Errorf("%w: more than %d opcodes", ErrScriptLimit, MaxScriptOps)
+	}
+
+	return nil
+}
+
+// checkMultiSig pops <sig 1> ... <sig m> m <key 1> ... <key n> n and reports
+// whether every signature is valid for a different key. Signatures have to
+// be in the same order as their keys.
+func (e *scriptEngine) checkMultiSig() (bool, error) {
+	n, err := e.popCount(MaxMultisigKeys)
+	if err != nil {
+		return false, err
+	}
+
+	// Every key counts towards the opcode limit, as checking it may cost a
+	// signature verification.
+	if err := e.countOps(n); err != nil {
+		return false, err
+	}
+
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := e.popCount(n)
+	if err != nil {
+		return false, err
+	}
+
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !e.checkSig(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+
+	return true, nil
+}
+
+// popCount pops a number between 0 and max.
+func (e *scriptEngine) popCount(max int) (int, error) {
+	v, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := decodeScriptNum(v, 4)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(max) {
+		return 0, fmt.Errorf("%w: count %d out of range 0 to %d", ErrScriptLimit, n, max)
+	}
+
+	return int(n), nil
+}
+
+// checkSig reports whether signature, an ed25519 signature followed by its
+// sighash type, signs the input with pubKey.
+func (e *scriptEngine) checkSig(signature, pubKey []byte) bool {
+	if len(pubKey) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize+1 {
+		return false
+	}
+
+	hashType := SigHashType(signature[ed25519.SignatureSize])
+
+	digest, err := SigHash(e.tx, e.idx, e.prevOuts, hashType, e.chainID)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(pubKey, digest, signature[:ed25519.SignatureSize])
+}
+
+func (e *scriptEngine) push(v []byte) {
+	e.stack = append(e.stack, v)
+}
+
+func (e *scriptEngine) pushBool(v bool) {
+	if v {
+		e.push([]byte{1})
+	} else {
+		e.push(nil)
+	}
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	v, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+
+	return v, nil
+}
+
+func (e *scriptEngine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+
+	return e.stack[len(e.stack)-1], nil
+}
+
+// asBool interprets a stack element: empty strings, zeros and negative
+// zero are false, anything else is true.
+func asBool(v []byte) bool {
+	for i, b := range v {
+		if b != 0 && !(i == len(v)-1 && b == 0x80) {
+			return true
+		}
+	}
+
+	return false
+}
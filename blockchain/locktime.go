@@ -0,0 +1,260 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+const (
+	// LockTimeThreshold separates the two meanings of Transaction.LockTime:
+	// below it the lock time is a block height, otherwise a unix time.
+	LockTimeThreshold = 500000000
+
+	// MaxSequence makes an input final. A transaction whose inputs are all
+	// final ignores its lock time.
+	MaxSequence uint32 = 0xffffffff
+	// SequenceLockDisabled turns off the relative lock of an input.
+	SequenceLockDisabled uint32 = 1 << 31
+	// SequenceLockTime makes the relative lock count time instead of
+	// blocks.
+	SequenceLockTime uint32 = 1 << 22
+	// SequenceLockMask selects the lock value from a sequence.
+	SequenceLockMask uint32 = 0x0000ffff
+	// SequenceLockGranularity is the shift from time based lock values to
+	// seconds; they count units of 512 seconds.
+	SequenceLockGranularity = 9
+)
+
+var ErrUnsatisfiedLock = errors.New("lock time not satisfied")
+
+// RelativeLockBlocks returns the input sequence locking the spent output
+// for blocks after the block that created it.
+func RelativeLockBlocks(blocks uint16) uint32 {
+	return uint32(blocks)
+}
+
+// RelativeLockSeconds returns the input sequence locking the spent output
+// for at least seconds after the block that created it, rounded up to
+// 512 second units.
+func RelativeLockSeconds(seconds uint32) uint32 {
+	units := (seconds + 1<<SequenceLockGranularity - 1) >> SequenceLockGranularity
+	if units > SequenceLockMask {
+		units = SequenceLockMask
+	}
+
+	return SequenceLockTime | units
+}
+
+// IsFinal reports whether tx can be included in a block at height whose
+// parent has the median time past mtp. A lock time below the threshold is
+// the last height, otherwise the last median time past, at which tx can't
+// be included yet.
+func (tx *Transaction) IsFinal(height int, mtp int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := int64(height)
+	if tx.LockTime >= LockTimeThreshold {
+		limit = mtp
+	}
+
+	if int64(tx.LockTime) < limit {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence != MaxSequence {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkSequenceLock makes sure the relative lock of in, which spends utxo,
+// has passed for a block at spendHeight whose parent has the median time
+// past spendTime. Time based locks count from the median time past of the
+// block before the one that created utxo.
+func (chain *Blockchain) checkSequenceLock(in TxInput, utxo UTXO, spendHeight int, spendTime int64) error {
+	if in.Sequence&SequenceLockDisabled != 0 {
+		return nil
+	}
+
+	value := in.Sequence & SequenceLockMask
+
+	if in.Sequence&SequenceLockTime == 0 {
+		if utxo.Height+int(value) > spendHeight {
+			return fmt.Errorf("%w: output of height %d is locked for %d blocks", ErrUnsatisfiedLock, utxo.Height, value)
+		}
+		return nil
+	}
+
+	prevHeight := utxo.Height - 1
+	if prevHeight < 0 {
+		prevHeight = 0
+	}
+
+	prev, err := chain.GetBlockByHeight(prevHeight)
+	if err != nil {
+		return err
+	}
+
+	coinTime, err := chain.MedianTimePast(prev)
+	if err != nil {
+		return err
+	}
+
+	unlockTime := coinTime + int64(value)<<SequenceLockGranularity
+	if unlockTime > spendTime {
+		return fmt.Errorf("%w: output is locked until median time %d, now %d", ErrUnsatisfiedLock, unlockTime, spendTime)
+	}
+
+	return nil
+}
+
+// checkLockTime runs OP_CHECKLOCKTIMEVERIFY: the transaction lock time has
+// to be of the same kind as lockTime and at least as late, and the input
+// can't be final, which would disable the lock time.
+func (e *scriptEngine) checkLockTime(lockTime int64) error {
+	txLockTime := int64(e.tx.LockTime)
+
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return fmt.Errorf("%w: lock time %d and transaction lock time %d differ in kind", ErrUnsatisfiedLock, lockTime, txLockTime)
+	}
+
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: transaction lock time %d is before %d", ErrUnsatisfiedLock, txLockTime, lockTime)
+	}
+
+	if e.tx.Inputs[e.idx].Sequence == MaxSequence {
+		return fmt.Errorf("%w: input is final", ErrUnsatisfiedLock)
+	}
+
+	return nil
+}
+
+// checkSequence runs OP_CHECKSEQUENCEVERIFY: unless the lock is disabled
+// the input sequence has to be a relative lock of the same kind and at
+// least as long.
+func (e *scriptEngine) checkSequence(lock int64) error {
+	sequence := uint32(lock)
+	if sequence&SequenceLockDisabled != 0 {
+		return nil
+	}
+
+	inSequence := e.tx.Inputs[e.idx].Sequence
+	if inSequence&SequenceLockDisabled != 0 {
+		return fmt.Errorf("%w: input has no relative lock", ErrUnsatisfiedLock)
+	}
+
+	if sequence&SequenceLockTime != inSequence&SequenceLockTime {
+		return fmt.Errorf("%w: relative lock %#x and input sequence %#x differ in kind", ErrUnsatisfiedLock, sequence, inSequence)
+	}
+
+	if sequence&SequenceLockMask > inSequence&SequenceLockMask {
+		return fmt.Errorf("%w: input sequence %#x is shorter than %#x", ErrUnsatisfiedLock, inSequence, sequence)
+	}
+
+	return nil
+}
+
+// FindTimeLocked returns the time locked outputs paying to pubKeyHash, see
+// NewTimeLockScript.
+func (u UTXOSet) FindTimeLocked(pubKeyHash []byte) []UTXO {
+	var utxos []UTXO
+
+	u.forEach(func(utxo UTXO) {
+		_, lockHash, ok := ExtractTimeLock(utxo.Output.LockingScript())
+		if ok && bytes.Equal(lockHash, pubKeyHash) {
+			utxos = append(utxos, utxo)
+		}
+	})
+
+	return utxos
+}
+
+// NewClaimTransaction creates a signed transaction sending every time
+// locked output of the wallet that can be spent in the next block to an
+// address, minus fee. A transaction has a single lock time, so outputs
+// locked until a time are only claimed once no outputs locked until a
+// height are left.
+func NewClaimTransaction(w *wallet.Wallet, to string, fee int, UTXO *UTXOSet) *Transaction {
+	chain := UTXO.Blockchain
+
+	tip, err := chain.GetLastBlock()
+	Handle(err)
+
+	spendHeight := tip.Height + 1
+	spendTime, err := chain.MedianTimePast(tip)
+	Handle(err)
+
+	var relative, byHeight, byTime []TxInput
+	values := make(map[string]int)
+
+	for _, utxo := range UTXO.FindTimeLocked(wallet.PublicKeyHash(w.PublicKeyBytes())) {
+		if !utxo.IsMature(spendHeight, chain.Policy.CoinbaseMaturity) {
+			continue
+		}
+
+		lock, _, _ := ExtractTimeLock(utxo.Output.LockingScript())
+		in := TxInput{ID: utxo.Outpoint.ID, Out: utxo.Outpoint.Index}
+
+		switch {
+		case lock.Relative:
+			in.Sequence = lock.Value
+			if chain.checkSequenceLock(in, utxo, spendHeight, spendTime) == nil {
+				relative = append(relative, in)
+			}
+		case lock.Value < LockTimeThreshold:
+			if int(lock.Value) < spendHeight {
+				byHeight = append(byHeight, in)
+			}
+		default:
+			if int64(lock.Value) < spendTime {
+				byTime = append(byTime, in)
+			}
+		}
+
+		values[utxo.Outpoint.String()] = utxo.Output.Value
+	}
+
+	tx := Transaction{
+		Inputs:    relative,
+		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
+	}
+
+	// The lock time only has to reach the locks, it is set as late as the
+	// next block allows.
+	if len(byHeight) > 0 {
+		tx.Inputs = append(tx.Inputs, byHeight...)
+		tx.LockTime = uint32(spendHeight - 1)
+	} else if len(byTime) > 0 {
+		tx.Inputs = append(tx.Inputs, byTime...)
+		tx.LockTime = uint32(spendTime - 1)
+	}
+
+	if len(tx.Inputs) == 0 {
+		log.Panic("Error: no unlocked funds")
+	}
+
+	total := 0
+	for _, in := range tx.Inputs {
+		total += values[Outpoint{ID: in.ID, Index: in.Out}.String()]
+	}
+
+	if fee < 0 || total <= fee {
+		log.Panic("Error: unlocked funds dont cover the fee")
+	}
+
+	tx.Outputs = []TxOutput{*NewTXOutput(total-fee, to)}
+	chain.SignTransaction(&tx, w.PrivateKey)
+
+	return &tx
+}
@@ -2,39 +2,139 @@ package blockchain
 
 import (
 	"bytes"
-	"log"
-
-	"github.com/cbergoon/merkletree"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 )
 
-type TxHash struct {
-	hash []byte
+// The merkle tree is built over a list of leaves, which are transaction IDs
+// for version 2 blocks. Leaves are used as they are, without hashing them
+// again. Every level pairs neighbouring nodes and hashes their
+// concatenation with SHA-256. A level with an odd number of nodes pairs the
+// last node with itself. The tree always has at least one level of hashing,
+// so the root of a single leaf L is SHA-256(L || L).
+
+var ErrTxNotInBlock = errors.New("transaction is not in block")
+
+// MerkleProof proves that a leaf is part of a tree. Hashes holds the
+// sibling of the leaf and of each of its ancestors, from the leaf level up.
+// Index is the position of the leaf; its bits, lowest first, tell whether
+// the node at each level is a right child.
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
 }
 
-func NewTxHash(hash []byte) TxHash {
-	return TxHash{hash: hash}
+func hashPair(left, right []byte) []byte {
+	data := make([]byte, 0, len(left)+len(right))
+	data = append(data, left...)
+	data = append(data, right...)
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
 }
 
-func (h TxHash) CalculateHash() ([]byte, error) {
-	return h.hash, nil
+// nextLevel hashes a level of the tree into its parent level.
+func nextLevel(level [][]byte) [][]byte {
+	var parents [][]byte
+
+	for i := 0; i < len(level); i += 2 {
+		right := i + 1
+		if right == len(level) {
+			right = i
+		}
+
+		parents = append(parents, hashPair(level[i], level[right]))
+	}
+
+	return parents
 }
 
-func (h TxHash) Equals(other merkletree.Content) (bool, error) {
-	equals := bytes.Equal(h.hash, other.(TxHash).hash)
-	return equals, nil
+// MerkleRoot returns the root of the tree over leaves, or nil if there are
+// no leaves.
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	level := nextLevel(leaves)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+
+	return level[0]
 }
 
-func NewMerkleTree(data [][]byte) *merkletree.MerkleTree {
-	var list []merkletree.Content
+// NewMerkleProof returns the proof for the leaf at index.
+func NewMerkleProof(leaves [][]byte, index int) (MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return MerkleProof{}, fmt.Errorf("leaf %d out of range, tree has %d leaves", index, len(leaves))
+	}
+
+	proof := MerkleProof{Index: index}
+	level := leaves
+
+	for {
+		sibling := index ^ 1
+		if sibling == len(level) {
+			sibling = index
+		}
+		proof.Hashes = append(proof.Hashes, level[sibling])
+
+		level = nextLevel(level)
+		index /= 2
+
+		if len(level) == 1 {
+			return proof, nil
+		}
+	}
+}
+
+// VerifyMerkleProof reports whether proof shows that txid is a leaf of the
+// tree with root.
+func VerifyMerkleProof(root, txid []byte, proof MerkleProof) bool {
+	if len(proof.Hashes) == 0 || proof.Index < 0 || proof.Index>>len(proof.Hashes) != 0 {
+		return false
+	}
+
+	hash := txid
+	index := proof.Index
+
+	for _, sibling := range proof.Hashes {
+		if index&1 == 0 {
+			hash = hashPair(hash, sibling)
+		} else {
+			hash = hashPair(sibling, hash)
+		}
+		index >>= 1
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// MerkleProof returns the proof that the transaction with txid is committed
+// to by the merkle root of the block.
+func (b *Block) MerkleProof(txid []byte) (MerkleProof, error) {
+	if b.Version < 2 {
+		return MerkleProof{}, fmt.Errorf("block version %d doesnt commit to transaction ids", b.Version)
+	}
+
+	b.SortTxs()
+
+	var leaves [][]byte
+	index := -1
 
-	for _, item := range data {
-		list = append(list, TxHash{hash: item})
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txid) {
+			index = i
+		}
+		leaves = append(leaves, tx.ID)
 	}
 
-	t, err := merkletree.NewTree(list)
-	if err != nil {
-		log.Fatal(err)
+	if index < 0 {
+		return MerkleProof{}, ErrTxNotInBlock
 	}
 
-	return t
+	return NewMerkleProof(leaves, index)
 }
@@ -0,0 +1,379 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	dbVersionKey = []byte("db-version")
+)
+
+// migration upgrades the on-disk layout from version-1 to version.
+type migration struct {
+	version int
+	name    string
+	run     func(chain *Blockchain) error
+}
+
+var migrations = []migration{
+	{version: 1, name: "chain_work", run: migrateChainWork},
+	{version: 2, name: "block_bits", run: migrateBlockBits},
+	{version: 3, name: "height_index", run: migrateHeightIndex},
+	{version: 4, name: "utxo_outpoints", run: migrateUTXOOutpoints},
+	{version: 5, name: "block_undo", run: migrateBlockUndo},
+	{version: 6, name: "utxo_coinbase", run: migrateUTXOCoinbase},
+	{version: 7, name: "canonical_encoding", run: migrateCanonicalEncoding},
+	{version: 8, name: "anchor_index", run: migrateAnchorIndex},
+	{version: 9, name: "canonical_records", run: migrateCanonicalRecords},
+}
+
+func latestDBVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (chain *Blockchain) dbVersion() int {
+	version := 0
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(dbVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		version = int(binary.BigEndian.Uint32(val))
+
+		return nil
+	})
+	Handle(err)
+
+	return version
+}
+
+func setDBVersion(txn *badger.Txn, version int) error {
+	val := make([]byte, 4)
+	binary.BigEndian.PutUint32(val, uint32(version))
+
+	return txn.Set(dbVersionKey, val)
+}
+
+// migrate brings databases created by older builds up to the latest layout.
+func (chain *Blockchain) migrate() error {
+	current := chain.dbVersion()
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		chain.Logger.Infow("running_db_migration",
+			"name", m.name,
+			"version", m.version,
+		)
+
+		if err := m.run(chain); err != nil {
+			return err
+		}
+
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return setDBVersion(txn, m.version)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mainChain returns the blocks of the active chain ordered from genesis to tip.
+func (chain *Blockchain) mainChain() []*Block {
+	var blocks []*Block
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+		blocks = append(blocks, block)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks
+}
+
+// migrateChainWork records cumulative work for every block of chains created
+// before fork choice existed. Those chains have no side branches.
+func migrateChainWork(chain *Blockchain) error {
+	work := big.NewInt(0)
+
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range chain.mainChain() {
+			work = new(big.Int).Add(work, chain.Consensus.Weight(block))
+
+			if err := setChainWork(txn, block.Hash, work); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateBlockBits stores the fixed target older builds mined with in every
+// block and recomputes cumulative work from it.
+func migrateBlockBits(chain *Blockchain) error {
+	bits := DifficultyToBits(legacyDifficulty)
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range chain.mainChain() {
+			if block.Bits != 0 {
+				continue
+			}
+
+			block.Bits = bits
+			if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return migrateChainWork(chain)
+}
+
+// migrateHeightIndex indexes the active chain by height.
+func migrateHeightIndex(chain *Blockchain) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range chain.mainChain() {
+			if err := setHeightIndex(txn, block); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// migrateUTXOOutpoints replaces the per-transaction output lists stored
+// under utxoPrefix with one entry per outpoint. The old lists can't be
+// converted in place because spending shifted the positions of the
+// remaining outputs, so the set is rebuilt from the chain.
+func migrateUTXOOutpoints(chain *Blockchain) error {
+	deleteByPrefix(chain.Database, utxoPrefix)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+
+	return nil
+}
+
+// migrateBlockUndo replays the active chain from genesis to record which
+// outputs every block spent.
+func migrateBlockUndo(chain *Blockchain) error {
+	unspent := make(map[string]UTXO)
+
+	for _, block := range chain.mainChain() {
+		undo := BlockUndo{}
+
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+					utxo, ok := unspent[outpoint.String()]
+					if !ok {
+						return fmt.Errorf("block %s spends unknown output %s", block.GetHash(), outpoint)
+					}
+
+					undo.Spent = append(undo.Spent, utxo)
+					delete(unspent, outpoint.String())
+				}
+			}
+
+			for outIdx, out := range tx.Outputs {
+				outpoint := Outpoint{ID: tx.ID, Index: outIdx}
+				unspent[outpoint.String()] = UTXO{Outpoint: outpoint, Output: out, Height: block.Height, Coinbase: tx.IsCoinbase()}
+			}
+		}
+
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return setBlockUndo(txn, block.Hash, undo)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateUTXOCoinbase flags coinbase outputs in the UTXO set and in the undo
+// records, which restore spent outputs on disconnect.
+func migrateUTXOCoinbase(chain *Blockchain) error {
+	UTXOSet := UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+
+	return migrateBlockUndo(chain)
+}
+
+// migrateCanonicalEncoding rewrites blocks stored as gob, including side
+// branch blocks, in the canonical encoding. Every stored block has its
+// chain work recorded, which is how they are found. Hashes and transaction
+// IDs are kept, so the blocks stay valid under the rules they were made with.
+func migrateCanonicalEncoding(chain *Blockchain) error {
+	var hashes [][]byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(workPrefix); it.ValidForPrefix(workPrefix); it.Next() {
+			hashes = append(hashes, it.Item().KeyCopy(nil)[len(workPrefix):])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(hash)
+			if err != nil {
+				return fmt.Errorf("block %x not found: %w", hash, err)
+			}
+
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !isLegacyEncoding(data) {
+				return nil
+			}
+
+			return txn.Set(hash, Deserialize(data).Serialize())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateAnchorIndex adds the data anchored on the active chain to the
+// transaction index, if the chain keeps one.
+func migrateAnchorIndex(chain *Blockchain) error {
+	if !chain.TxIndexEnabled() {
+		return nil
+	}
+
+	for _, block := range chain.mainChain() {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return indexBlockAnchors(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateCanonicalRecords rewrites the unspent outputs, undo records and
+// transaction index entries stored as gob in the canonical encoding.
+func migrateCanonicalRecords(chain *Blockchain) error {
+	records := []struct {
+		prefix   []byte
+		reencode func([]byte) []byte
+	}{
+		{coinPrefix, func(data []byte) []byte { return DeserializeUTXO(data).Serialize() }},
+		{undoPrefix, func(data []byte) []byte { return DeserializeBlockUndo(data).Serialize() }},
+		{txIndexPrefix, func(data []byte) []byte { return DeserializeTxLocation(data).Serialize() }},
+		{anchorIndexPrefix, func(data []byte) []byte { return DeserializeTxLocation(data).Serialize() }},
+	}
+
+	for _, record := range records {
+		if err := rewriteLegacyRecords(chain.Database, record.prefix, record.reencode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteLegacyRecords re-encodes every gob value under prefix. The values
+// are written in batches, since a database transaction has a size limit.
+func rewriteLegacyRecords(db *badger.DB, prefix []byte, reencode func([]byte) []byte) error {
+	const batchSize = 10000
+
+	var keys, values [][]byte
+
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !isLegacyEncoding(data) {
+				continue
+			}
+
+			keys = append(keys, it.Item().KeyCopy(nil))
+			values = append(values, reencode(data))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keys); start += batchSize {
+		end := min(start+batchSize, len(keys))
+
+		err := db.Update(func(txn *badger.Txn) error {
+			for i := start; i < end; i++ {
+				if err := txn.Set(keys[i], values[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/dgraph-io/badger"
+)
+
+// storeLegacyRecords rewrites the values under prefix as gob, the way older
+// builds stored them, and returns the decoded values by key.
+func storeLegacyRecords(t *testing.T, db *badger.DB, prefix []byte, decode func([]byte) any) map[string]any {
+	t.Helper()
+
+	records := make(map[string]any)
+
+	err := db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			data, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			records[string(it.Item().KeyCopy(nil))] = decode(data)
+		}
+
+		for key, record := range records {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(key), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 {
+		t.Fatalf("no records under %q", prefix)
+	}
+
+	return records
+}
+
+func TestMigrateCanonicalRecords(t *testing.T) {
+	chain, alice := newTestChain(t, "1")
+	chain.ReindexTransactions()
+
+	bob := wallet.MakeWallet()
+	tx := NewTransaction(alice, string(bob.Address()), 20, 1, &UTXOSet{Blockchain: chain})
+	mineTestBlock(t, chain, alice, tx)
+	mineTestBlock(t, chain, bob, NewDataTransaction(bob, []byte("anchored"), 1, &UTXOSet{Blockchain: chain}))
+
+	aliceBalance, bobBalance := balance(t, chain, alice), balance(t, chain, bob)
+
+	prefixes := map[string]func([]byte) any{
+		string(coinPrefix):        func(data []byte) any { return DeserializeUTXO(data) },
+		string(undoPrefix):        func(data []byte) any { return DeserializeBlockUndo(data) },
+		string(txIndexPrefix):     func(data []byte) any { return DeserializeTxLocation(data) },
+		string(anchorIndexPrefix): func(data []byte) any { return DeserializeTxLocation(data) },
+	}
+
+	legacy := make(map[string]map[string]any)
+	for prefix, decode := range prefixes {
+		legacy[prefix] = storeLegacyRecords(t, chain.Database, []byte(prefix), decode)
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return setDBVersion(txn, 8)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chain.migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for prefix, records := range legacy {
+		for key, want := range records {
+			var data []byte
+			err := chain.Database.View(func(txn *badger.Txn) error {
+				item, err := txn.Get([]byte(key))
+				if err != nil {
+					return err
+				}
+				data, err = item.ValueCopy(nil)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data[0] != EncodingVersion {
+				t.Fatalf("%q record %x left with encoding %#x", prefix, key, data[0])
+			}
+			if got := prefixes[prefix](data); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q record %x migrated to %+v, want %+v", prefix, key, got, want)
+			}
+		}
+	}
+
+	if got := balance(t, chain, alice); got != aliceBalance {
+		t.Fatalf("alice has %d after the migration, want %d", got, aliceBalance)
+	}
+	if got := balance(t, chain, bob); got != bobBalance {
+		t.Fatalf("bob has %d after the migration, want %d", got, bobBalance)
+	}
+
+	// The undo records still disconnect the tip.
+	if _, err := chain.DisconnectTip(); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, chain, alice); got != aliceBalance {
+		t.Fatalf("alice has %d after disconnecting the tip, want %d", got, aliceBalance)
+	}
+}
@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	monetaryPolicyKey = []byte("monetary-policy")
+
+	// DefaultMonetaryPolicy is used by chains created before the policy
+	// was stored, which all belong to the main network.
+	DefaultMonetaryPolicy = NetworkMonetaryPolicy(&params.MainNet)
+)
+
+// NetworkMonetaryPolicy returns the policy new chains of a network get
+// unless they are created with explicit settings.
+func NetworkMonetaryPolicy(p *params.Params) MonetaryPolicy {
+	return MonetaryPolicy{
+		InitialSubsidy:   p.InitialSubsidy,
+		HalvingInterval:  p.HalvingInterval,
+		MaxSupply:        p.MaxSupply,
+		CoinbaseMaturity: p.CoinbaseMaturity,
+	}
+}
+
+// MonetaryPolicy defines how many new coins a block may create. The subsidy
+// starts at InitialSubsidy and halves every HalvingInterval blocks. A
+// positive MaxSupply caps the total amount of coins ever issued. Coinbase
+// outputs can only be spent CoinbaseMaturity blocks after the block that
+// created them, so rewards of blocks that may still be reorganized away
+// can't be spent yet.
+type MonetaryPolicy struct {
+	InitialSubsidy   int
+	HalvingInterval  int
+	MaxSupply        int
+	CoinbaseMaturity int
+}
+
+func (p MonetaryPolicy) Validate() error {
+	if p.InitialSubsidy <= 0 {
+		return errors.New("initial subsidy has to be positive")
+	}
+	if p.HalvingInterval < 0 {
+		return errors.New("halving interval can't be negative")
+	}
+	if p.MaxSupply < 0 {
+		return errors.New("max supply can't be negative")
+	}
+	if p.CoinbaseMaturity < 0 {
+		return errors.New("coinbase maturity can't be negative")
+	}
+
+	return nil
+}
+
+// uncappedSupply sums the subsidies of blocks 0 to height, one halving
+// epoch at a time.
+func (p MonetaryPolicy) uncappedSupply(height int) int {
+	total := 0
+	remaining := height + 1
+
+	for epoch := 0; remaining > 0 && epoch < 63; epoch++ {
+		blocks := remaining
+		if p.HalvingInterval > 0 && blocks > p.HalvingInterval {
+			blocks = p.HalvingInterval
+		}
+
+		total += blocks * (p.InitialSubsidy >> epoch)
+		remaining -= blocks
+	}
+
+	return total
+}
+
+// ScheduledSupply returns the amount of coins blocks 0 to height may issue.
+func (p MonetaryPolicy) ScheduledSupply(height int) int {
+	if height < 0 {
+		return 0
+	}
+
+	supply := p.uncappedSupply(height)
+	if p.MaxSupply > 0 && supply > p.MaxSupply {
+		supply = p.MaxSupply
+	}
+
+	return supply
+}
+
+// Subsidy returns the amount of new coins the block at height may create.
+func (p MonetaryPolicy) Subsidy(height int) int {
+	return p.ScheduledSupply(height) - p.ScheduledSupply(height-1)
+}
+
+func (p MonetaryPolicy) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+
+	err := encoder.Encode(p)
+	Handle(err)
+
+	return buffer.Bytes()
+}
+
+func setMonetaryPolicy(txn *badger.Txn, policy MonetaryPolicy) error {
+	return txn.Set(monetaryPolicyKey, policy.Serialize())
+}
+
+func (chain *Blockchain) loadMonetaryPolicy() (MonetaryPolicy, error) {
+	policy := DefaultMonetaryPolicy
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(monetaryPolicyKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		// gob leaves out zero fields, so decode into an empty policy.
+		policy = MonetaryPolicy{}
+
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(&policy)
+	})
+
+	return policy, err
+}
+
+// Supply reports coin issuance up to a height.
+type Supply struct {
+	Height    int
+	Scheduled int
+	Issued    int
+	Fees      int
+}
+
+// IssuedSupply audits the active chain up to height: Issued is what the
+// coinbase transactions actually created, that is their outputs minus the
+// fees they collected, and Scheduled is what the monetary policy allows.
+func (chain *Blockchain) IssuedSupply(height int) (Supply, error) {
+	supply := Supply{
+		Height:    height,
+		Scheduled: chain.Policy.ScheduledSupply(height),
+	}
+
+	blocks, err := chain.GetBlocksByHeightRange(0, height)
+	if err != nil {
+		return supply, err
+	}
+
+	if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
+		return supply, fmt.Errorf("block at height - %d not found", height)
+	}
+
+	for _, block := range blocks {
+		undo, err := chain.GetBlockUndo(block.Hash)
+		if err != nil {
+			return supply, err
+		}
+
+		fees := 0
+		for _, utxo := range undo.Spent {
+			fees += utxo.Output.Value
+		}
+
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Outputs {
+				if tx.IsCoinbase() {
+					supply.Issued += out.Value
+				} else {
+					fees -= out.Value
+				}
+			}
+		}
+
+		supply.Issued -= fees
+		supply.Fees += fees
+	}
+
+	return supply, nil
+}
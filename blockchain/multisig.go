@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+// PartialTx is a transaction spending multisig funds while the signatures
+// of the key holders are collected. It carries everything a signer needs,
+// so it can be passed around as a file without access to the chain.
+type PartialTx struct {
+	Tx           Transaction
+	PrevOuts     map[string]TxOutput
+	RedeemScript []byte
+	ChainID      []byte
+	// Signatures holds the signatures of every input by hex public key.
+	Signatures []map[string][]byte
+}
+
+// NewMultisigTransaction creates an unsigned transaction sending amount
+// from the pay to script hash address of redeemScript to an address. Fee
+// and change work like in NewTransaction, the change goes back to the
+// multisig address.
+func NewMultisigTransaction(redeemScript []byte, to string, amount, fee int, UTXO *UTXOSet) *PartialTx {
+	if amount <= 0 || fee < 0 {
+		log.Panic("Error: amount has to be positive and fee can't be negative")
+	}
+
+	if _, _, ok := ExtractMultisig(redeemScript); !ok {
+		log.Panic("Error: redeem script is not a multisig script")
+	}
+
+	lockingScript := PayToScriptHashScript(wallet.ScriptHash(redeemScript))
+	acc, validOutputs := UTXO.FindSpendableScriptOutputs(lockingScript, amount+fee)
+
+	if acc < amount+fee {
+		log.Panic("Error: not enough funds")
+	}
+
+	partial := &PartialTx{
+		PrevOuts:     make(map[string]TxOutput),
+		RedeemScript: redeemScript,
+		ChainID:      UTXO.Blockchain.ChainID(),
+	}
+
+	var inputs []TxInput
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		Handle(err)
+
+		for _, out := range outs {
+			outpoint := Outpoint{ID: txID, Index: out}
+
+			utxo, err := UTXO.GetUTXO(outpoint)
+			Handle(err)
+
+			inputs = append(inputs, TxInput{ID: txID, Out: out})
+			partial.PrevOuts[outpoint.String()] = utxo.Output
+		}
+	}
+
+	outputs := []TxOutput{*NewTXOutput(amount, to)}
+
+	if acc > amount+fee {
+		outputs = append(outputs, TxOutput{Value: acc - amount - fee, ScriptPubKey: lockingScript})
+	}
+
+	partial.Tx = Transaction{
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
+	}
+	partial.Signatures = make([]map[string][]byte, len(inputs))
+
+	return partial
+}
+
+// Sign adds the signatures of privKey to every input. The key has to be
+// one of the multisig keys.
+func (p *PartialTx) Sign(privKey ed25519.PrivateKey) error {
+	pubKey := []byte(privKey.Public().(ed25519.PublicKey))
+
+	if !p.HasKey(pubKey) {
+		return fmt.Errorf("key %x is not part of the multisig", pubKey)
+	}
+
+	for idx := range p.Tx.Inputs {
+		signature, err := p.Tx.SignatureForInput(idx, privKey, p.PrevOuts, SigHashAll, p.ChainID)
+		if err != nil {
+			return err
+		}
+
+		if p.Signatures[idx] == nil {
+			p.Signatures[idx] = make(map[string][]byte)
+		}
+		p.Signatures[idx][hex.EncodeToString(pubKey)] = signature
+	}
+
+	return nil
+}
+
+// HasKey reports whether pubKey is one of the multisig keys.
+func (p *PartialTx) HasKey(pubKey []byte) bool {
+	_, pubKeys, _ := ExtractMultisig(p.RedeemScript)
+
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SignatureCount returns how many signatures the least signed input has
+// and how many every input needs.
+func (p *PartialTx) SignatureCount() (collected, required int) {
+	required, _, _ = ExtractMultisig(p.RedeemScript)
+
+	for idx := range p.Tx.Inputs {
+		if idx == 0 || len(p.Signatures[idx]) < collected {
+			collected = len(p.Signatures[idx])
+		}
+	}
+
+	return collected, required
+}
+
+// Finalize builds the unlocking scripts from the collected signatures and
+// returns the signed transaction.
+func (p *PartialTx) Finalize() (*Transaction, error) {
+	required, pubKeys, ok := ExtractMultisig(p.RedeemScript)
+	if !ok {
+		return nil, fmt.Errorf("redeem script is not a multisig script")
+	}
+
+	tx := p.Tx
+	tx.Inputs = append([]TxInput{}, p.Tx.Inputs...)
+
+	for idx := range tx.Inputs {
+		// OP_CHECKMULTISIG expects the signatures in the order of the keys.
+		var signatures [][]byte
+		for _, pubKey := range pubKeys {
+			signature, ok := p.Signatures[idx][hex.EncodeToString(pubKey)]
+			if ok && len(signatures) < required {
+				signatures = append(signatures, signature)
+			}
+		}
+
+		if len(signatures) < required {
+			return nil, fmt.Errorf("input %d has %d of %d signatures", idx, len(signatures), required)
+		}
+
+		tx.Inputs[idx].ScriptSig = MultisigUnlockScript(signatures, p.RedeemScript)
+	}
+
+	tx.ID = tx.Hash()
+
+	if err := tx.Verify(p.PrevOuts, p.ChainID); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+func (p PartialTx) Serialize() []byte {
+	data, err := json.MarshalIndent(p, "", "  ")
+	Handle(err)
+
+	return data
+}
+
+func DeserializePartialTx(data []byte) (*PartialTx, error) {
+	var partial PartialTx
+
+	if err := json.Unmarshal(data, &partial); err != nil {
+		return nil, err
+	}
+
+	if len(partial.Signatures) != len(partial.Tx.Inputs) {
+		return nil, fmt.Errorf("partial transaction has signatures for %d of %d inputs", len(partial.Signatures), len(partial.Tx.Inputs))
+	}
+
+	return &partial, nil
+}
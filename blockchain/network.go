@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/dgraph-io/badger"
+)
+
+var networkKey = []byte("network")
+
+func setNetwork(txn *badger.Txn, p *params.Params) error {
+	return txn.Set(networkKey, []byte(p.Name))
+}
+
+// checkNetwork makes sure the database was created for the selected
+// network. Databases created before networks existed belong to the main
+// network.
+func (chain *Blockchain) checkNetwork() error {
+	name := params.MainNet.Name
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(networkKey)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		val, err := item.ValueCopy(nil)
+		name = string(val)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if name != chain.Params.Name {
+		return fmt.Errorf("blockchain belongs to %s, not %s", name, chain.Params.Name)
+	}
+
+	return nil
+}
@@ -2,41 +2,63 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const Difficulty = 18
-
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
+	// MaxNonce is the highest nonce Mine tries before it rolls the extra
+	// nonce of the coinbase.
+	MaxNonce int
+
+	hashes atomic.Uint64
 }
 
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-
-	target.Lsh(target, uint(256-Difficulty))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofOfWork{
-		Block:  b,
-		Target: target,
+		Block:    b,
+		Target:   target,
+		MaxNonce: DefaultMaxNonce,
 	}
 
 	return pow
 }
 
+// legacyDifficulty is the fixed difficulty older builds mined with. Their
+// block hashes cover the number itself, not the compact target migrated
+// blocks store in Bits.
+const legacyDifficulty = 18
+
+// InitData returns the data hashed for nonce: the serialized header, or for
+// legacy blocks the fields older builds hashed.
 func (pow *ProofOfWork) InitData(nonce int) []byte {
+	if pow.Block.Version > 0 {
+		header := pow.Block.Header()
+		header.Nonce = int64(nonce)
+
+		return header.Serialize()
+	}
+
 	data := bytes.Join(
 		[][]byte{
 			pow.Block.PrevHash,
 			pow.Block.JsonHashTransactions(),
 			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
+			ToHex(int64(legacyDifficulty)),
 		},
 		[]byte{},
 	)
@@ -44,38 +66,170 @@ func (pow *ProofOfWork) InitData(nonce int) []byte {
 	return data
 }
 
+// DefaultMaxNonce limits the nonce search to 32 bits, like the header
+// nonce of other chains; the extra nonce provides the rest.
+const DefaultMaxNonce = math.MaxUint32
+
+// hashrateLogInterval is how often Seal logs the mining progress.
+const hashrateLogInterval = 10 * time.Second
+
+// headerNonceOffset is where the nonce starts in a serialized header.
+const headerNonceOffset = 80
+
+// nonceCheckInterval is how many nonces a worker tries between checks
+// whether it should stop.
+const nonceCheckInterval = 1 << 12
+
+var ErrNonceSpaceExhausted = errors.New("nonce space exhausted")
+
+// MiningResult is the outcome of a successful Mine.
+type MiningResult struct {
+	Nonce           int
+	Hash            []byte
+	Hashes          uint64
+	Elapsed         time.Duration
+	ExtraNonceRolls int
+}
+
+// Hashrate returns the hashes per second the search ran at.
+func (r *MiningResult) Hashrate() float64 {
+	return hashrate(r.Hashes, r.Elapsed)
+}
+
+func hashrate(hashes uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(hashes) / elapsed.Seconds()
+}
+
+// Run searches for a nonce on every core and returns it with the block
+// hash.
 func (pow *ProofOfWork) Run() (int, []byte) {
+	result, err := pow.Mine(context.Background(), runtime.NumCPU())
+	Handle(err)
+
+	return result.Nonce, result.Hash
+}
+
+// Mine searches for a nonce whose block hash is below the target with
+// workers goroutines; worker i tries i, i+workers, i+2*workers and so on.
+// Once the nonces up to MaxNonce are used up it rolls the extra nonce of
+// the coinbase and searches again. It stops early with the context error
+// once ctx is done. Hashes tells how many hashes were computed so far,
+// also while the search runs.
+func (pow *ProofOfWork) Mine(ctx context.Context, workers int) (*MiningResult, error) {
+	start := time.Now()
+
+	for rolls := 0; ; rolls++ {
+		result, err := pow.mineNonce(ctx, workers)
+		if errors.Is(err, ErrNonceSpaceExhausted) {
+			if err := pow.Block.IncrementExtraNonce(); err != nil {
+				return nil, ErrNonceSpaceExhausted
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result.Hashes = pow.Hashes()
+		result.Elapsed = time.Since(start)
+		result.ExtraNonceRolls = rolls
+
+		return result, nil
+	}
+}
+
+// mineNonce runs one search of the nonces up to MaxNonce.
+func (pow *ProofOfWork) mineNonce(ctx context.Context, workers int) (*MiningResult, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	searchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var once sync.Once
+	var result *MiningResult
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(first int) {
+			defer wg.Done()
+
+			nonce, hash, ok := pow.search(searchCtx, first, workers)
+			if !ok {
+				return
+			}
+
+			once.Do(func() {
+				result = &MiningResult{Nonce: nonce, Hash: hash}
+				stop()
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if result == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNonceSpaceExhausted
+	}
+
+	return result, nil
+}
+
+// Hashes returns how many hashes Mine computed.
+func (pow *ProofOfWork) Hashes() uint64 {
+	return pow.hashes.Load()
+}
+
+// search tries the nonces from first on in steps of step until one is
+// below the target, ctx is done or the nonces run out.
+func (pow *ProofOfWork) search(ctx context.Context, first, step int) (int, []byte, bool) {
 	var intHash big.Int
-	var hash [32]byte
 
-	nonce := 0
+	// The serialized header only differs in the nonce, so it is encoded
+	// once and the nonce patched in. Legacy blocks hash other fields.
+	var header []byte
+	if pow.Block.Version > 0 {
+		header = pow.InitData(0)
+	}
 
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
+	tried := uint64(0)
+	for nonce := first; nonce >= 0 && nonce <= pow.MaxNonce; nonce += step {
+		var hash [32]byte
+		if header != nil {
+			binary.BigEndian.PutUint64(header[headerNonceOffset:], uint64(nonce))
+			hash = sha256.Sum256(header)
+		} else {
+			hash = sha256.Sum256(pow.InitData(nonce))
+		}
 
-		fmt.Printf("\r%x", hash)
+		tried++
 		intHash.SetBytes(hash[:])
-
 		if intHash.Cmp(pow.Target) == -1 {
-			// pow.Logger.Infow("block_mined_with_params",
-			// 	"prev_hash", pow.Block.PrevHash,
-			// 	"txs_hash", pow.Block.HashTransactions(),
-			// 	"nonce", ToHex(int64(nonce)),
-			// 	"difficulty", ToHex(int64(Difficulty)),
-			// 	"txs_len", len(pow.Block.Transactions),
-			// 	"txs", pow.Block.TxIds(),
-			// 	"tx_info", pow.Block.TxInfo(),
-			// )
-
-			break
-		} else {
-			nonce++
+			pow.hashes.Add(tried)
+			return nonce, hash[:], true
 		}
 
+		if tried == nonceCheckInterval {
+			pow.hashes.Add(tried)
+			tried = 0
+
+			if ctx.Err() != nil {
+				return 0, nil, false
+			}
+		}
 	}
 
-	return nonce, hash[:]
+	pow.hashes.Add(tried)
+
+	return 0, nil, false
 }
 
 func (pow *ProofOfWork) Validate() bool {
@@ -87,7 +241,7 @@ func (pow *ProofOfWork) Validate() bool {
 	// 	"prev_hash", pow.Block.PrevHash,
 	// 	"txs_hash", pow.Block.HashTransactions(),
 	// 	"nonce", ToHex(int64(pow.Block.Nonce)),
-	// 	"difficulty", ToHex(int64(Difficulty)),
+	// 	"bits", ToHex(int64(legacyDifficulty)),
 	// 	"txs_len", len(pow.Block.Transactions),
 	// 	"txs", pow.Block.TxIds(),
 	// 	"tx_info", pow.Block.TxInfo(),
@@ -99,6 +253,15 @@ func (pow *ProofOfWork) Validate() bool {
 	return intHash.Cmp(pow.Target) == -1
 }
 
+// Work returns the expected number of hashes needed to find a block below
+// the proof target: 2^256 / (target + 1).
+func (pow *ProofOfWork) Work() *big.Int {
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(pow.Target, big.NewInt(1))
+
+	return numerator.Div(numerator, denominator)
+}
+
 func ToHex(num int64) []byte {
 	buff := new(bytes.Buffer)
 	err := binary.Write(buff, binary.BigEndian, num)
@@ -108,3 +271,121 @@ func ToHex(num int64) []byte {
 
 	return buff.Bytes()
 }
+
+// ProofOfWorkConsensus accepts blocks whose hash meets the target set by
+// NextBits, and chooses the branch with the most expected hashes. Workers
+// is how many goroutines search for a nonce, zero means one per CPU.
+type ProofOfWorkConsensus struct {
+	Workers int
+}
+
+func (c *ProofOfWorkConsensus) Name() string {
+	return ProofOfWorkEngine
+}
+
+// Prepare sets the target block has to meet.
+func (c *ProofOfWorkConsensus) Prepare(chain *Blockchain, block, parent *Block) error {
+	bits, err := c.requiredBits(chain, parent)
+	if err != nil {
+		return err
+	}
+
+	block.Bits = bits
+
+	return nil
+}
+
+func (c *ProofOfWorkConsensus) requiredBits(chain *Blockchain, parent *Block) (uint32, error) {
+	if parent == nil {
+		return DifficultyToBits(chain.Params.InitialDifficulty), nil
+	}
+
+	return chain.NextBits(parent)
+}
+
+// Seal mines block, logging the hashrate while it runs.
+func (c *ProofOfWorkConsensus) Seal(ctx context.Context, chain *Blockchain, block *Block) error {
+	workers := c.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	pow := NewProof(block)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(hashrateLogInterval)
+		defer ticker.Stop()
+
+		start := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				hashes := pow.Hashes()
+				chain.Logger.Infow("mining_progress",
+					"block_height", block.Height,
+					"hashes", hashes,
+					"hashrate", hashrate(hashes, time.Since(start)),
+				)
+			}
+		}
+	}()
+
+	result, err := pow.Mine(ctx, workers)
+	if err != nil {
+		chain.Logger.Infow("mining_stopped",
+			"block_height", block.Height,
+			"hashes", pow.Hashes(),
+			"error", err,
+		)
+		return err
+	}
+
+	block.Nonce = result.Nonce
+	block.Hash = result.Hash
+
+	chain.Logger.Infow("new_block_mined",
+		"block_hash", block.GetHash(),
+		"block_height", block.Height,
+		"workers", workers,
+		"hashes", result.Hashes,
+		"elapsed", result.Elapsed,
+		"hashrate", result.Hashrate(),
+		"extra_nonce_rolls", result.ExtraNonceRolls,
+	)
+
+	return nil
+}
+
+// VerifySeal checks that block has the required target and meets it.
+func (c *ProofOfWorkConsensus) VerifySeal(chain *Blockchain, block, parent *Block) error {
+	bits, err := c.requiredBits(chain, parent)
+	if err != nil {
+		return err
+	}
+
+	if block.Bits != bits {
+		chain.Logger.Warnw("block_bits_invalid",
+			"hash", block.GetHash(),
+			"bits", fmt.Sprintf("%08x", block.Bits),
+			"expected_bits", fmt.Sprintf("%08x", bits),
+		)
+		return ruleError(RejectBadBits, block.GetHash(), "bits %08x dont match required %08x", block.Bits, bits)
+	}
+
+	if !NewProof(block).Validate() {
+		chain.Logger.Warnw("block_pow_validation_failed", "hash", block.GetHash())
+		return ruleError(RejectBadPoW, block.GetHash(), "pow validation failed")
+	}
+
+	return nil
+}
+
+// Weight is the work of block.
+func (c *ProofOfWorkConsensus) Weight(block *Block) *big.Int {
+	return NewProof(block).Work()
+}
@@ -0,0 +1,290 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scripts are the programs locking outputs and unlocking inputs. They are a
+// sequence of opcodes; opcodes up to OpPushData2 push data onto the stack
+// and the others operate on it. The opcode values are the ones Bitcoin uses.
+const (
+	Op0         byte = 0x00
+	OpPushData1 byte = 0x4c
+	OpPushData2 byte = 0x4d
+	Op1Negate   byte = 0x4f
+	Op1         byte = 0x51
+	Op16        byte = 0x60
+
+	OpNop    byte = 0x61
+	OpIf     byte = 0x63
+	OpNotIf  byte = 0x64
+	OpElse   byte = 0x67
+	OpEndIf  byte = 0x68
+	OpVerify byte = 0x69
+	OpReturn byte = 0x6a
+
+	OpDrop byte = 0x75
+	OpDup  byte = 0x76
+	OpSwap byte = 0x7c
+	OpSize byte = 0x82
+
+	OpEqual       byte = 0x87
+	OpEqualVerify byte = 0x88
+
+	OpSha256              byte = 0xa8
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+
+	OpCheckLockTimeVerify byte = 0xb1
+	OpCheckSequenceVerify byte = 0xb2
+)
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	Op1Negate:             "OP_1NEGATE",
+	OpNop:                 "OP_NOP",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpSwap:                "OP_SWAP",
+	OpSize:                "OP_SIZE",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSha256:              "OP_SHA256",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+var (
+	errTruncatedPush = errors.New("push past end of script")
+	errBadScriptNum  = errors.New("invalid script number")
+)
+
+// scriptOp is a parsed opcode with the data it pushes.
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.code <= Op16 && op.code != 0x50
+}
+
+// parseScript splits script into opcodes. It only fails on pushes running
+// past the end of the script; unknown opcodes fail when they are executed.
+func parseScript(script []byte) ([]scriptOp, error) {
+	var ops []scriptOp
+
+	for i := 0; i < len(script); {
+		code := script[i]
+		i++
+
+		var size int
+		switch {
+		case code > Op0 && code < OpPushData1:
+			size = int(code)
+		case code == OpPushData1:
+			if i+1 > len(script) {
+				return nil, errTruncatedPush
+			}
+			size = int(script[i])
+			i++
+		case code == OpPushData2:
+			if i+2 > len(script) {
+				return nil, errTruncatedPush
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+
+		if i+size > len(script) {
+			return nil, errTruncatedPush
+		}
+
+		op := scriptOp{code: code}
+		if size > 0 {
+			op.data = script[i : i+size]
+		}
+		ops = append(ops, op)
+
+		i += size
+	}
+
+	return ops, nil
+}
+
+// IsPushOnly reports whether script only pushes data.
+func IsPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsUnspendable reports whether no input can ever spend an output locked
+// with script: it starts with OP_RETURN or is too big to run. Such outputs
+// are kept out of the UTXO set.
+func IsUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == OpReturn) || len(script) > MaxScriptSize
+}
+
+// DisasmScript returns a human readable form of script.
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[invalid script %x]", script)
+	}
+
+	var parts []string
+	for _, op := range ops {
+		switch {
+		case op.data != nil:
+			parts = append(parts, hex.EncodeToString(op.data))
+		case op.code >= Op1 && op.code <= Op16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.code-Op1+1))
+		case opcodeNames[op.code] != "":
+			parts = append(parts, opcodeNames[op.code])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN_%#x", op.code))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// ScriptBuilder assembles a script from opcodes and data.
+type ScriptBuilder struct {
+	script []byte
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode.
+func (b *ScriptBuilder) AddOp(op byte) *ScriptBuilder {
+	b.script = append(b.script, op)
+
+	return b
+}
+
+// AddData appends the smallest push of data.
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch {
+	case len(data) == 0:
+		b.script = append(b.script, Op0)
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		b.script = append(b.script, Op1+data[0]-1)
+	case len(data) == 1 && data[0] == 0x81:
+		b.script = append(b.script, Op1Negate)
+	case len(data) < int(OpPushData1):
+		b.script = append(b.script, byte(len(data)))
+		b.script = append(b.script, data...)
+	case len(data) <= 0xff:
+		b.script = append(b.script, OpPushData1, byte(len(data)))
+		b.script = append(b.script, data...)
+	default:
+		b.script = append(b.script, OpPushData2)
+		b.script = binary.LittleEndian.AppendUint16(b.script, uint16(len(data)))
+		b.script = append(b.script, data...)
+	}
+
+	return b
+}
+
+// AddInt64 appends the push of n as a script number.
+func (b *ScriptBuilder) AddInt64(n int64) *ScriptBuilder {
+	return b.AddData(encodeScriptNum(n))
+}
+
+// Script returns the assembled script.
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// encodeScriptNum encodes n the way scripts represent numbers: little
+// endian with the sign in the highest bit of the last byte, and zero as an
+// empty byte string.
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+		result = append(result, byte(abs))
+		abs >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// decodeScriptNum parses a number encoded by encodeScriptNum. It rejects
+// numbers longer than maxLen bytes and encodings that aren't the shortest.
+func decodeScriptNum(v []byte, maxLen int) (int64, error) {
+	if len(v) > maxLen {
+		return 0, fmt.Errorf("%w: %d bytes, max %d", errBadScriptNum, len(v), maxLen)
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	// Only a sign byte may have its lower bits unset, and only if the
+	// byte before it would otherwise be taken for the sign.
+	if v[len(v)-1]&0x7f == 0 && (len(v) == 1 || v[len(v)-2]&0x80 == 0) {
+		return 0, fmt.Errorf("%w: %x is not minimally encoded", errBadScriptNum, v)
+	}
+
+	var n int64
+	for i, b := range v {
+		n |= int64(b) << (8 * i)
+	}
+
+	if v[len(v)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(v) - 1))
+		n = -n
+	}
+
+	return n, nil
+}
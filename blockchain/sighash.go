@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// SigHashType selects the parts of a transaction a signature commits to.
+// It is appended to the signature as its last byte.
+type SigHashType byte
+
+const (
+	// SigHashAll commits to every input and output.
+	SigHashAll SigHashType = 0x01
+	// SigHashNone commits to the inputs only, anyone can choose the outputs.
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle commits to the inputs and to the output with the same
+	// index as the signed input.
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay can be combined with the types above to commit to
+	// the signed input only, so others can add inputs.
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashBaseMask = 0x1f
+)
+
+func (t SigHashType) base() SigHashType {
+	return t & sigHashBaseMask
+}
+
+func (t SigHashType) anyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+// IsValid reports whether t is one of the defined types.
+func (t SigHashType) IsValid() bool {
+	if t&^(SigHashAnyoneCanPay|sigHashBaseMask) != 0 {
+		return false
+	}
+
+	switch t.base() {
+	case SigHashAll, SigHashNone, SigHashSingle:
+		return true
+	}
+
+	return false
+}
+
+// SigHash returns the digest signed for input idx of tx. prevOuts holds
+// the outputs spent by the inputs, keyed by Outpoint.String(), and chainID
+// is the hash of the genesis block, so signatures can't be replayed on
+// another chain. The digest is SHA-256 of the following, encoded as
+// described at EncodingVersion:
+//
+//	bytes   chain id
+//	int32   transaction version
+//	int64   transaction timestamp
+//	byte    sighash type
+//	varint  input count, 1 with ANYONECANPAY
+//	  bytes   previous txid         \
+//	  int32   output index          | every input, or only the
+//	  int64   spent value           | signed one with ANYONECANPAY
+//	  bytes   spent locking script  |
+//	  uint32  sequence              /
+//	varint  signed input position among the inputs above
+//	varint  output count, 0 with NONE and 1 with SINGLE
+//	  int64   value               \ every output, none, or the one at
+//	  bytes   locking script      / the signed input's index with SINGLE
+//	uint32  lock time
+//
+// Unlocking scripts aren't signed, they hold the signatures.
+func SigHash(tx *Transaction, idx int, prevOuts map[string]TxOutput, hashType SigHashType, chainID []byte) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.Inputs) {
+		return nil, fmt.Errorf("input %d out of range", idx)
+	}
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("unknown sighash type %#x", byte(hashType))
+	}
+
+	e := &encoder{}
+	e.bytes(chainID)
+	e.int32(tx.Version)
+	e.int64(tx.Timestamp)
+	e.buf = append(e.buf, byte(hashType))
+
+	inputs := tx.Inputs
+	position := idx
+	if hashType.anyoneCanPay() {
+		inputs = tx.Inputs[idx : idx+1]
+		position = 0
+	}
+
+	e.varint(len(inputs))
+	for _, in := range inputs {
+		outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+		prevOut, ok := prevOuts[outpoint.String()]
+		if !ok {
+			return nil, fmt.Errorf("spent output %s not found", outpoint)
+		}
+
+		e.bytes(in.ID)
+		e.int32(int32(in.Out))
+		e.int64(int64(prevOut.Value))
+		e.bytes(prevOut.LockingScript())
+		e.uint32(in.Sequence)
+	}
+	e.varint(position)
+
+	var outputs []TxOutput
+	switch hashType.base() {
+	case SigHashAll:
+		outputs = tx.Outputs
+	case SigHashSingle:
+		if idx >= len(tx.Outputs) {
+			return nil, fmt.Errorf("sighash single input %d has no matching output", idx)
+		}
+		outputs = tx.Outputs[idx : idx+1]
+	}
+
+	e.varint(len(outputs))
+	for _, out := range outputs {
+		e.int64(int64(out.Value))
+		e.bytes(out.LockingScript())
+	}
+	e.uint32(tx.LockTime)
+
+	hash := sha256.Sum256(e.buf)
+
+	return hash[:], nil
+}
@@ -0,0 +1,328 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/aadejanovs/blockchain-demo/wallet"
+)
+
+// ScriptClass names the standard script templates.
+type ScriptClass string
+
+const (
+	NonStandardScript ScriptClass = "nonstandard"
+	PubKeyHashScript  ScriptClass = "pubkeyhash"
+	ScriptHashScript  ScriptClass = "scripthash"
+	MultisigScript    ScriptClass = "multisig"
+	TimeLockScript    ScriptClass = "timelock"
+	HTLCScript        ScriptClass = "htlc"
+	NullDataScript    ScriptClass = "nulldata"
+)
+
+// MaxDataCarrierSize is the maximum size of the data a null data output
+// carries.
+const MaxDataCarrierSize = 80
+
+// PayToPubKeyHashScript returns the standard locking script paying to the
+// owner of the key hashing to pubKeyHash:
+//
+//	OP_DUP OP_SHA256 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OpDup).
+		AddOp(OpSha256).
+		AddData(pubKeyHash).
+		AddOp(OpEqualVerify).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// PayToPubKeyHashUnlockScript returns the script spending a pay to public
+// key hash output: <signature> <pubKey>.
+func PayToPubKeyHashUnlockScript(signature, pubKey []byte) []byte {
+	return NewScriptBuilder().
+		AddData(signature).
+		AddData(pubKey).
+		Script()
+}
+
+// ExtractPubKeyHash returns the public key hash a pay to public key hash
+// script pays to.
+func ExtractPubKeyHash(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, false
+	}
+
+	return matchPubKeyHash(ops)
+}
+
+func matchPubKeyHash(ops []scriptOp) ([]byte, bool) {
+	if len(ops) != 5 {
+		return nil, false
+	}
+
+	if ops[0].code != OpDup || ops[1].code != OpSha256 || len(ops[2].data) != sha256.Size ||
+		ops[3].code != OpEqualVerify || ops[4].code != OpCheckSig {
+		return nil, false
+	}
+
+	return ops[2].data, true
+}
+
+// PayToScriptHashScript returns the standard locking script paying to the
+// script hashing to scriptHash, see VerifyScript:
+//
+//	OP_SHA256 <scriptHash> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().
+		AddOp(OpSha256).
+		AddData(scriptHash).
+		AddOp(OpEqual).
+		Script()
+}
+
+// ExtractScriptHash returns the script hash a pay to script hash script
+// pays to.
+func ExtractScriptHash(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil, false
+	}
+
+	if ops[0].code != OpSha256 || len(ops[1].data) != sha256.Size || ops[2].code != OpEqual {
+		return nil, false
+	}
+
+	return ops[1].data, true
+}
+
+// NewMultisigScript returns the script that needs signatures of required of
+// the pubKeys, in the order of the keys:
+//
+//	<required> <key 1> ... <key n> <n> OP_CHECKMULTISIG
+func NewMultisigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d keys, got %d", MaxMultisigKeys, len(pubKeys))
+	}
+	if required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("required signatures %d out of range 1 to %d", required, len(pubKeys))
+	}
+
+	builder := NewScriptBuilder().AddInt64(int64(required))
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key %x has %d bytes, expected %d", pubKey, len(pubKey), ed25519.PublicKeySize)
+		}
+		builder.AddData(pubKey)
+	}
+
+	return builder.AddInt64(int64(len(pubKeys))).AddOp(OpCheckMultiSig).Script(), nil
+}
+
+// ExtractMultisig returns the required signature count and keys of a
+// script built by NewMultisigScript.
+func ExtractMultisig(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].code != OpCheckMultiSig {
+		return 0, nil, false
+	}
+
+	required, ok := smallInt(ops[0])
+	count, ok2 := smallInt(ops[len(ops)-2])
+	if !ok || !ok2 || count != len(ops)-3 || required < 1 || required > count {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if len(op.data) != ed25519.PublicKeySize {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+
+	return required, pubKeys, true
+}
+
+// smallInt returns the number pushed by OP_1 to OP_16.
+func smallInt(op scriptOp) (int, bool) {
+	if op.code < Op1 || op.code > Op16 {
+		return 0, false
+	}
+
+	return int(op.code-Op1) + 1, true
+}
+
+// MultisigUnlockScript returns the script spending a pay to script hash
+// output locked to a multisig redeem script:
+//
+//	<sig 1> ... <sig m> <redeemScript>
+func MultisigUnlockScript(signatures [][]byte, redeemScript []byte) []byte {
+	builder := NewScriptBuilder()
+	for _, signature := range signatures {
+		builder.AddData(signature)
+	}
+
+	return builder.AddData(redeemScript).Script()
+}
+
+// TimeLock is the condition of a time locked output. Absolute locks hold a
+// lock time, like Transaction.LockTime, relative ones an input sequence.
+type TimeLock struct {
+	Relative bool
+	Value    uint32
+}
+
+// NewTimeLockScript returns a pay to public key hash script that can't be
+// spent before lock has passed:
+//
+//	<value> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_SHA256 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// Relative locks use OP_CHECKSEQUENCEVERIFY instead.
+func NewTimeLockScript(lock TimeLock, pubKeyHash []byte) []byte {
+	op := OpCheckLockTimeVerify
+	if lock.Relative {
+		op = OpCheckSequenceVerify
+	}
+
+	prefix := NewScriptBuilder().
+		AddInt64(int64(lock.Value)).
+		AddOp(op).
+		AddOp(OpDrop).
+		Script()
+
+	return append(prefix, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// ExtractTimeLock returns the lock and public key hash of a script built by
+// NewTimeLockScript.
+func ExtractTimeLock(script []byte) (TimeLock, []byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 8 || ops[2].code != OpDrop {
+		return TimeLock{}, nil, false
+	}
+
+	var lock TimeLock
+	switch ops[1].code {
+	case OpCheckLockTimeVerify:
+	case OpCheckSequenceVerify:
+		lock.Relative = true
+	default:
+		return TimeLock{}, nil, false
+	}
+
+	value, ok := pushedNum(ops[0])
+	if !ok || value < 0 || value > int64(MaxSequence) {
+		return TimeLock{}, nil, false
+	}
+	lock.Value = uint32(value)
+
+	pubKeyHash, ok := matchPubKeyHash(ops[3:])
+	if !ok {
+		return TimeLock{}, nil, false
+	}
+
+	return lock, pubKeyHash, true
+}
+
+// pushedNum returns the number a push opcode puts on the stack.
+func pushedNum(op scriptOp) (int64, bool) {
+	if n, ok := smallInt(op); ok {
+		return int64(n), true
+	}
+	if !op.isPush() || op.code == Op1Negate {
+		return 0, false
+	}
+
+	n, err := decodeScriptNum(op.data, 5)
+
+	return n, err == nil
+}
+
+// lockingKeyHash returns the public key hash a pay to public key hash
+// script, plain or time locked, pays to. Both are spent with a signature
+// and the public key.
+func lockingKeyHash(script []byte) ([]byte, bool) {
+	if pubKeyHash, ok := ExtractPubKeyHash(script); ok {
+		return pubKeyHash, true
+	}
+
+	_, pubKeyHash, ok := ExtractTimeLock(script)
+
+	return pubKeyHash, ok
+}
+
+// NewNullDataScript returns the script of an unspendable output carrying
+// data: OP_RETURN <data>.
+func NewNullDataScript(data []byte) []byte {
+	return NewScriptBuilder().AddOp(OpReturn).AddData(data).Script()
+}
+
+// ExtractNullData returns the data a script built by NewNullDataScript
+// carries. A lone OP_RETURN carries no data.
+func ExtractNullData(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) == 0 || len(ops) > 2 || ops[0].code != OpReturn {
+		return nil, false
+	}
+
+	if len(ops) == 1 {
+		return nil, true
+	}
+	if !ops[1].isPush() {
+		return nil, false
+	}
+
+	if n, ok := smallInt(ops[1]); ok {
+		return []byte{byte(n)}, true
+	}
+	if ops[1].code == Op1Negate {
+		return []byte{0x81}, true
+	}
+
+	return ops[1].data, true
+}
+
+// AddressScript returns the standard locking script paying to address.
+func AddressScript(address string) ([]byte, error) {
+	version, hash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case wallet.PubKeyHashVersion():
+		return PayToPubKeyHashScript(hash), nil
+	case wallet.ScriptHashVersion():
+		return PayToScriptHashScript(hash), nil
+	}
+
+	return nil, fmt.Errorf("address %s has unknown version %#x", address, version)
+}
+
+// ClassifyScript returns the template a locking script follows.
+func ClassifyScript(script []byte) ScriptClass {
+	if _, ok := ExtractPubKeyHash(script); ok {
+		return PubKeyHashScript
+	}
+	if _, ok := ExtractScriptHash(script); ok {
+		return ScriptHashScript
+	}
+	if _, _, ok := ExtractMultisig(script); ok {
+		return MultisigScript
+	}
+	if _, _, ok := ExtractTimeLock(script); ok {
+		return TimeLockScript
+	}
+	if _, ok := ExtractHTLC(script); ok {
+		return HTLCScript
+	}
+	if _, ok := ExtractNullData(script); ok {
+		return NullDataScript
+	}
+
+	return NonStandardScript
+}
@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MedianTimeBlocks is the number of blocks whose median timestamp a new
+	// block has to exceed.
+	MedianTimeBlocks = 11
+	// MaxFutureBlockTime is how far ahead of the network adjusted time a
+	// block timestamp may be.
+	MaxFutureBlockTime = 2 * time.Minute
+
+	maxTimeOffset  = 10 * time.Minute
+	maxTimeSamples = 200
+	// minTimeSamples is how many peers have to report their time before
+	// the node adjusts its clock, so that a single peer can't move it.
+	minTimeSamples = 5
+)
+
+// MedianTimeSource is the local clock adjusted by the median offset peers
+// reported in their version messages. The clock isn't adjusted until
+// minTimeSamples peers reported, and a median larger than maxTimeOffset is
+// ignored, so a few peers with wrong clocks can't move the node far off.
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	offset  int64
+}
+
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{
+		offsets: make(map[string]int64),
+	}
+}
+
+// AddTimeSample records the time a peer reported. peer has to identify
+// where the sample came from, not what the peer says about itself, since
+// only the first sample of every peer is used.
+func (m *MedianTimeSource) AddTimeSample(peer string, peerTime int64) {
+	m.addOffset(peer, peerTime-time.Now().Unix())
+}
+
+func (m *MedianTimeSource) addOffset(peer string, offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.offsets[peer]; ok || len(m.offsets) >= maxTimeSamples {
+		return
+	}
+
+	m.offsets[peer] = offset
+
+	if len(m.offsets) < minTimeSamples {
+		return
+	}
+
+	// The local clock counts as a sample with no offset.
+	offsets := []int64{0}
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	median := offsets[len(offsets)/2]
+	if len(offsets)%2 == 0 {
+		median = (offsets[len(offsets)/2-1] + median) / 2
+	}
+
+	if median > int64(maxTimeOffset/time.Second) || median < -int64(maxTimeOffset/time.Second) {
+		median = 0
+	}
+
+	m.offset = median
+}
+
+// Offset returns the current adjustment in seconds.
+func (m *MedianTimeSource) Offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.offset
+}
+
+// AdjustedTime returns the network adjusted unix time.
+func (m *MedianTimeSource) AdjustedTime() int64 {
+	return time.Now().Unix() + m.Offset()
+}
+
+// MedianTimePast returns the median timestamp of block and up to
+// MedianTimeBlocks-1 of its ancestors.
+func (chain *Blockchain) MedianTimePast(block *Block) (int64, error) {
+	timestamps := []int64{block.Timestamp}
+
+	for len(timestamps) < MedianTimeBlocks && len(block.PrevHash) != 0 {
+		parent, err := chain.GetBlock(block.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+
+		block = &parent
+		timestamps = append(timestamps, block.Timestamp)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// NextBlockTime returns the timestamp for a block built on top of parent:
+// the adjusted time, or just past the median time past if that is later.
+func (chain *Blockchain) NextBlockTime(parent *Block) (int64, error) {
+	mtp, err := chain.MedianTimePast(parent)
+	if err != nil {
+		return 0, err
+	}
+
+	now := chain.TimeSource.AdjustedTime()
+	if now <= mtp {
+		now = mtp + 1
+	}
+
+	return now, nil
+}
+
+// checkBlockTime validates the timestamp of a block built on top of parent.
+func (chain *Blockchain) checkBlockTime(block *Block, parent *Block) error {
+	mtp, err := chain.MedianTimePast(parent)
+	if err != nil {
+		return err
+	}
+
+	if block.Timestamp <= mtp {
+		return ruleError(RejectTimeTooOld, block.GetHash(), "timestamp %d is not after median time past %d", block.Timestamp, mtp)
+	}
+
+	maxTime := chain.TimeSource.AdjustedTime() + int64(MaxFutureBlockTime/time.Second)
+	if block.Timestamp > maxTime {
+		return ruleError(RejectTimeTooNew, block.GetHash(), "timestamp %d is too far in the future, max %d", block.Timestamp, maxTime)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMedianTimeSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int64
+		want    int64
+	}{
+		{"no samples", nil, 0},
+		{"single peer", []int64{-540}, 0},
+		{"below minimum", []int64{-540, -540, -540, -540}, 0},
+		// The local clock makes the sixth sample.
+		{"even count", []int64{-540, -540, -540, -540, -540}, -540},
+		{"odd count", []int64{10, 20, 30, 40, 50, 60}, 30},
+		{"median between samples", []int64{-100, -100, -100, 100, 100}, -50},
+		{"outliers", []int64{-9000, 9000, 5, 6, 7}, 5},
+		{"median too far ahead", []int64{601, 601, 601, 601, 601}, 0},
+		{"median too far behind", []int64{-601, -601, -601, -601, -601, -601}, 0},
+		{"median at the limit", []int64{600, 600, 600, 600, 600, 600}, 600},
+	}
+
+	for _, test := range tests {
+		m := NewMedianTimeSource()
+		for i, offset := range test.offsets {
+			m.addOffset(fmt.Sprintf("peer%d", i), offset)
+		}
+
+		if got := m.Offset(); got != test.want {
+			t.Errorf("%s: offset %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMedianTimeSourceUsesFirstSamplePerPeer(t *testing.T) {
+	m := NewMedianTimeSource()
+
+	for i := 0; i < minTimeSamples; i++ {
+		m.addOffset("peer", -300)
+	}
+	if got := m.Offset(); got != 0 {
+		t.Fatalf("one peer reporting %d times moved the clock by %d", minTimeSamples, got)
+	}
+
+	for i := 1; i < minTimeSamples; i++ {
+		m.addOffset(fmt.Sprintf("peer%d", i), 100)
+	}
+	if got := m.Offset(); got != 100 {
+		t.Fatalf("offset %d, want 100", got)
+	}
+}
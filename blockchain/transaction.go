@@ -3,9 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ed25519"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -17,26 +15,33 @@ import (
 	"github.com/aadejanovs/blockchain-demo/wallet"
 )
 
+// TxVersion is the version new transactions have to use. Version 4
+// transactions have a lock time and input sequences, see IsFinal. Version 3
+// transactions lock outputs with scripts and unlock inputs with scripts run
+// by VerifyScript. Version 2 transactions paid to a public key hash and
+// signed the digest described at SigHash, version 1 transactions signed a
+// hash of the whole transaction without the spent amounts, and version 0
+// transactions, stored by older builds, have IDs that aren't the hash of
+// their canonical encoding.
+const TxVersion = 4
+
 type Transaction struct {
 	ID        []byte
 	Inputs    []TxInput
 	Outputs   []TxOutput
 	Timestamp int64
+	Version   int32  `json:",omitempty"`
+	LockTime  uint32 `json:",omitempty"`
 }
 
 func (tx *Transaction) GetID() string {
 	return hex.EncodeToString(tx.ID)
 }
 
+// Serialize returns the canonical encoding of the transaction, see
+// EncodingVersion.
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	if err := enc.Encode(tx); err != nil {
-		log.Panic(err)
-	}
-
-	return encoded.Bytes()
+	return EncodeTransaction(&tx)
 }
 
 func (tx Transaction) JsonSerialize() []byte {
@@ -45,53 +50,45 @@ func (tx Transaction) JsonSerialize() []byte {
 	return result
 }
 
+// Hash returns the transaction ID: the hash of the encoded transaction
+// without the ID itself.
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-
-	txCopy := *tx
-	txCopy.ID = []byte{}
+	e := &encoder{}
+	tx.encodeBody(e)
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(e.buf)
 
 	return hash[:]
 }
 
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	Handle(err)
 
-	return transaction
+	return *transaction
 }
 
-func CoinbaseTx(to, data string) *Transaction {
-	if data == "" {
-		randData := make([]byte, 24)
-		_, err := rand.Read(randData)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		data = fmt.Sprintf("%x", randData)
-	}
-
+// CoinbaseTx creates the transaction that pays reward, the block subsidy
+// plus the fees of the other transactions in the block, to the miner. data
+// is an optional message kept in the coinbase input.
+func CoinbaseTx(to, data string, reward int) *Transaction {
+	// The coinbase input spends nothing, its script is never run. It
+	// starts with the extra nonce the miner rolls, see
+	// IncrementExtraNonce, followed by data.
 	txin := TxInput{
 		ID:        nil,
 		Out:       -1,
-		Signature: nil,
-		// Non existing pub key
-		PubKey: []byte(data),
+		ScriptSig: coinbaseScript(randomExtraNonce(), []byte(data)),
 	}
 
-	txout := NewTXOutput(20, to)
+	txout := NewTXOutput(reward, to)
 
 	tx := Transaction{
 		ID:        nil,
 		Inputs:    []TxInput{txin},
 		Outputs:   []TxOutput{*txout},
 		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
 	}
 
 	tx.ID = tx.Hash()
@@ -99,14 +96,31 @@ func CoinbaseTx(to, data string) *Transaction {
 	return &tx
 }
 
-func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+// NewTransaction creates a signed transaction sending amount from the wallet
+// to an address. fee is left unclaimed by the outputs for the miner to
+// collect; whatever the selected inputs hold above amount and fee goes back
+// to the wallet as change.
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, UTXO *UTXOSet) *Transaction {
+	lockingScript, err := AddressScript(to)
+	Handle(err)
+
+	return NewScriptTransaction(w, lockingScript, amount, fee, UTXO)
+}
+
+// NewScriptTransaction creates a transaction like NewTransaction that locks
+// amount with lockingScript.
+func NewScriptTransaction(w *wallet.Wallet, lockingScript []byte, amount, fee int, UTXO *UTXOSet) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
+	if amount <= 0 || fee < 0 {
+		log.Panic("Error: amount has to be positive and fee can't be negative")
+	}
+
 	pubKeyHash := wallet.PublicKeyHash(w.PublicKeyBytes())
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("Error: not enough funds")
 	}
 
@@ -116,20 +130,18 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 
 		for _, out := range outs {
 			input := TxInput{
-				ID:        txID,
-				Out:       out,
-				Signature: nil,
-				PubKey:    w.PublicKeyBytes(),
+				ID:  txID,
+				Out: out,
 			}
 
 			inputs = append(inputs, input)
 		}
 	}
 
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	outputs = append(outputs, TxOutput{Value: amount, ScriptPubKey: lockingScript})
 
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, string(w.Address())))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, string(w.Address())))
 	}
 
 	tx := Transaction{
@@ -137,94 +149,129 @@ func NewTransaction(w *wallet.Wallet, to string, amount int, UTXO *UTXOSet) *Tra
 		Inputs:    inputs,
 		Outputs:   outputs,
 		Timestamp: time.Now().UnixNano(),
+		Version:   TxVersion,
 	}
-	tx.ID = tx.Hash()
 	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
 
 	return &tx
 }
 
+// NewTransactionWithFeeRate creates a transaction like NewTransaction whose
+// fee is feeRate per byte of the signed transaction.
+func NewTransactionWithFeeRate(w *wallet.Wallet, to string, amount, feeRate int, UTXO *UTXOSet) *Transaction {
+	lockingScript, err := AddressScript(to)
+	Handle(err)
+
+	return NewScriptTransactionWithFeeRate(w, lockingScript, amount, feeRate, UTXO)
+}
+
+// NewScriptTransactionWithFeeRate creates a transaction like
+// NewScriptTransaction whose fee is feeRate per byte.
+func NewScriptTransactionWithFeeRate(w *wallet.Wallet, lockingScript []byte, amount, feeRate int, UTXO *UTXOSet) *Transaction {
+	fee := 0
+
+	// Paying a fee can pull in more inputs, which makes the transaction
+	// bigger, so the size is recomputed until the fee covers it.
+	for {
+		tx := NewScriptTransaction(w, lockingScript, amount, fee, UTXO)
+
+		required := feeRate * tx.Size()
+		if fee >= required {
+			return tx
+		}
+
+		fee = required
+	}
+}
+
+// Size returns the length of the serialized transaction in bytes.
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func (tx *Transaction) Sign(privKey ed25519.PrivateKey, prevTXs map[string]Transaction) {
+// Sign signs every input of tx with SigHashAll and sets its ID, which
+// covers the signatures. prevOuts holds the outputs spent by the inputs,
+// keyed by Outpoint.String(), and chainID is the genesis block hash.
+func (tx *Transaction) Sign(privKey ed25519.PrivateKey, prevOuts map[string]TxOutput, chainID []byte) {
 	if tx.IsCoinbase() {
 		return
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	for idx := range tx.Inputs {
+		if err := tx.SignInput(idx, privKey, prevOuts, SigHashAll, chainID); err != nil {
+			log.Panic(err)
 		}
 	}
+}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
+// SignInput signs input idx with hashType and updates the ID. Signing
+// inputs one by one lets several parties fund a transaction; see
+// SigHashAnyoneCanPay. The spent output has to pay to the public key hash
+// of privKey, plainly or with a time lock. Time locks are only satisfied if
+// the lock time and sequences are set before signing.
+func (tx *Transaction) SignInput(idx int, privKey ed25519.PrivateKey, prevOuts map[string]TxOutput, hashType SigHashType, chainID []byte) error {
+	if idx < 0 || idx >= len(tx.Inputs) {
+		return fmt.Errorf("input %d out of range", idx)
+	}
 
-		signature := ed25519.Sign(privKey, []byte(fmt.Sprintf("%x", txCopy)))
+	in := tx.Inputs[idx]
+	outpoint := Outpoint{ID: in.ID, Index: in.Out}
 
-		tx.Inputs[inId].Signature = signature
-		txCopy.Inputs[inId].PubKey = nil
+	prevOut, ok := prevOuts[outpoint.String()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingPrevOut, outpoint)
 	}
-}
 
-func (tx *Transaction) TrimmedCopy() Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
+	pubKey := []byte(privKey.Public().(ed25519.PublicKey))
 
-	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{
-			ID:        in.ID,
-			Out:       in.Out,
-			Signature: nil,
-			PubKey:    nil,
-		})
+	pubKeyHash, ok := lockingKeyHash(prevOut.LockingScript())
+	if !ok || !bytes.Equal(pubKeyHash, wallet.PublicKeyHash(pubKey)) {
+		return fmt.Errorf("output %s isnt paid to the signing key", outpoint)
 	}
 
-	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{
-			Value:      out.Value,
-			PubKeyHash: out.PubKeyHash,
-		})
+	signature, err := tx.SignatureForInput(idx, privKey, prevOuts, hashType, chainID)
+	if err != nil {
+		return err
 	}
 
-	txCopy := Transaction{
-		ID:      tx.ID,
-		Inputs:  inputs,
-		Outputs: outputs,
-	}
+	tx.Inputs[idx].ScriptSig = PayToPubKeyHashUnlockScript(signature, pubKey)
+	tx.ID = tx.Hash()
 
-	return txCopy
+	return nil
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
+// SignatureForInput returns the signature of input idx as scripts expect
+// it: the ed25519 signature of the SigHash digest followed by hashType.
+func (tx *Transaction) SignatureForInput(idx int, privKey ed25519.PrivateKey, prevOuts map[string]TxOutput, hashType SigHashType, chainID []byte) ([]byte, error) {
+	digest, err := SigHash(tx, idx, prevOuts, hashType, chainID)
+	if err != nil {
+		return nil, err
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inId, in := range tx.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+	signature := ed25519.Sign(privKey, digest)
 
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
+	return append(signature, byte(hashType)), nil
+}
 
-		result := ed25519.Verify(in.PubKey, []byte(fmt.Sprintf("%x", txCopy)), in.Signature)
+// Verify runs the scripts of every input of tx, see VerifyScript. prevOuts
+// holds the outputs spent by the inputs, keyed by Outpoint.String(), and
+// chainID is the genesis block hash.
+func (tx *Transaction) Verify(prevOuts map[string]TxOutput, chainID []byte) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
 
-		if !result {
-			return false
+	for idx := range tx.Inputs {
+		if err := VerifyScript(tx, idx, prevOuts, chainID); err != nil {
+			return fmt.Errorf("input %d: %w", idx, err)
 		}
-
-		txCopy.Inputs[inId].PubKey = nil
 	}
 
-	return true
+	return nil
 }
 
 func (tx Transaction) String() string {
@@ -235,14 +282,21 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		switch {
+		case tx.IsCoinbase() && input.ScriptSig != nil:
+			lines = append(lines, fmt.Sprintf("       Coinbase:  %x", input.ScriptSig))
+		case input.ScriptSig != nil:
+			lines = append(lines, fmt.Sprintf("       Script:    %s", DisasmScript(input.ScriptSig)))
+		default:
+			lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+			lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.LockingScript())))
 	}
 
 	return strings.Join(lines, "\n")
@@ -2,16 +2,19 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
-
-	"github.com/aadejanovs/blockchain-demo/wallet"
 )
 
+// TxInput spends an output. Inputs of version 3 transactions and later
+// unlock it with ScriptSig; Signature and PubKey are only set on inputs of
+// older transactions. Sequence holds the relative lock of the input, see
+// SequenceLockDisabled.
 type TxInput struct {
 	ID        []byte
 	Out       int
 	Signature []byte
 	PubKey    []byte
+	ScriptSig []byte `json:",omitempty"`
+	Sequence  uint32 `json:",omitempty"`
 }
 
 type TxInputSort []TxInput
@@ -20,19 +23,21 @@ func (s TxInputSort) Len() int           { return len(s) }
 func (s TxInputSort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s TxInputSort) Less(i, j int) bool { return string(s[i].ID) < string(s[j].ID) }
 
+// TxOutput holds value locked by a script. Outputs of version 3
+// transactions and later set ScriptPubKey; outputs of older transactions only store
+// PubKeyHash, see LockingScript.
 type TxOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	PubKeyHash   []byte
+	ScriptPubKey []byte `json:",omitempty"`
 }
 
 type TxOutputSort []TxOutput
 
-func (s TxOutputSort) Len() int           { return len(s) }
-func (s TxOutputSort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-func (s TxOutputSort) Less(i, j int) bool { return string(s[i].PubKeyHash) < string(s[j].PubKeyHash) }
-
-type TxOutputs struct {
-	Outputs []TxOutput
+func (s TxOutputSort) Len() int      { return len(s) }
+func (s TxOutputSort) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s TxOutputSort) Less(i, j int) bool {
+	return string(s[i].LockingScript()) < string(s[j].LockingScript())
 }
 
 func NewTXOutput(value int, address string) *TxOutput {
@@ -45,38 +50,36 @@ func NewTXOutput(value int, address string) *TxOutput {
 	return txo
 }
 
-func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-
-	err := encoder.Encode(outs)
-	Handle(err)
-
-	return buffer.Bytes()
+// NewDataOutput returns an unspendable output carrying data, see
+// NewNullDataScript.
+func NewDataOutput(data []byte) *TxOutput {
+	return &TxOutput{ScriptPubKey: NewNullDataScript(data)}
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
-	var outs TxOutputs
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-
-	err := decoder.Decode(&outs)
+// Lock locks the output to address with the standard script of its
+// address type.
+func (out *TxOutput) Lock(address []byte) {
+	script, err := AddressScript(string(address))
 	Handle(err)
 
-	return outs
+	out.ScriptPubKey = script
 }
 
-func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
-
-	return bytes.Equal(lockingHash, pubKeyHash)
-}
+// LockingScript returns the script locking the output. Outputs stored
+// before scripts existed are locked by the pay to public key hash script of
+// their PubKeyHash.
+func (out *TxOutput) LockingScript() []byte {
+	if out.ScriptPubKey == nil && out.PubKeyHash != nil {
+		return PayToPubKeyHashScript(out.PubKeyHash)
+	}
 
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	return out.ScriptPubKey
 }
 
+// IsLockedWithKey reports whether the output pays to pubKeyHash with the
+// pay to public key hash script.
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	lockingHash, ok := ExtractPubKeyHash(out.LockingScript())
+
+	return ok && bytes.Equal(lockingHash, pubKeyHash)
 }
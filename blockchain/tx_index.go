@@ -0,0 +1,185 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	txIndexPrefix     = []byte("tx-")
+	txIndexEnabledKey = []byte("txindex")
+
+	ErrTxNotFound = errors.New("Transaction does not exist")
+)
+
+// TxLocation points at a transaction inside an active chain block.
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+// TxLookup is a transaction together with the block that confirmed it.
+type TxLookup struct {
+	Transaction   *Transaction
+	Block         *Block
+	Position      int
+	Confirmations int
+}
+
+// Serialize returns the canonical encoding of the location, see
+// EncodingVersion.
+func (loc TxLocation) Serialize() []byte {
+	return EncodeTxLocation(loc)
+}
+
+// DeserializeTxLocation decodes a transaction location, including locations
+// stored as gob by older builds.
+func DeserializeTxLocation(data []byte) TxLocation {
+	if isLegacyEncoding(data) {
+		var loc TxLocation
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&loc)
+		Handle(err)
+
+		return loc
+	}
+
+	loc, err := DecodeTxLocation(data)
+	Handle(err)
+
+	return loc
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+// indexBlockTransactions indexes the transactions of block and the data
+// they anchor.
+func indexBlockTransactions(txn *badger.Txn, block *Block) error {
+	for pos, tx := range block.Transactions {
+		loc := TxLocation{BlockHash: block.Hash, Position: pos}
+		if err := txn.Set(txIndexKey(tx.ID), loc.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return indexBlockAnchors(txn, block)
+}
+
+func unindexBlockTransactions(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+
+	return unindexBlockAnchors(txn, block)
+}
+
+// TxIndexEnabled reports whether the transaction index is maintained.
+func (chain *Blockchain) TxIndexEnabled() bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(txIndexEnabledKey)
+		return err
+	})
+
+	return err == nil
+}
+
+// ReindexTransactions rebuilds the transaction index from the active chain
+// and keeps it up to date from then on.
+func (chain *Blockchain) ReindexTransactions() int {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	deleteByPrefix(chain.Database, txIndexPrefix)
+	deleteByPrefix(chain.Database, anchorIndexPrefix)
+
+	count := 0
+
+	for _, block := range chain.mainChain() {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return indexBlockTransactions(txn, block)
+		})
+		Handle(err)
+
+		count += len(block.Transactions)
+	}
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(txIndexEnabledKey, []byte{1})
+	})
+	Handle(err)
+
+	chain.txIndex = true
+
+	return count
+}
+
+// LookupTransaction finds an active chain transaction and its block. It uses
+// the transaction index when it is enabled and scans the chain otherwise.
+func (chain *Blockchain) LookupTransaction(ID []byte) (*TxLookup, error) {
+	var block *Block
+	var position int
+
+	if chain.txIndex {
+		var loc TxLocation
+
+		err := chain.Database.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(txIndexKey(ID))
+			if err != nil {
+				return err
+			}
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			loc = DeserializeTxLocation(val)
+
+			return nil
+		})
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, ErrTxNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := chain.GetBlock(loc.BlockHash)
+		if err != nil {
+			return nil, fmt.Errorf("indexed block %x not found: %w", loc.BlockHash, err)
+		}
+
+		block, position = &b, loc.Position
+	} else {
+		iter := chain.Iterator()
+
+	Blocks:
+		for {
+			b := iter.Next()
+
+			for pos, tx := range b.Transactions {
+				if bytes.Equal(tx.ID, ID) {
+					block, position = b, pos
+					break Blocks
+				}
+			}
+
+			if len(b.PrevHash) == 0 {
+				return nil, ErrTxNotFound
+			}
+		}
+	}
+
+	return &TxLookup{
+		Transaction:   block.Transactions[position],
+		Block:         block,
+		Position:      position,
+		Confirmations: chain.GetBestHeight() - block.Height + 1,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	undoPrefix = []byte("undo-")
+)
+
+// BlockUndo holds the outputs a block spent, in the order its inputs spent
+// them, so that the block can be disconnected without rescanning the chain.
+type BlockUndo struct {
+	Spent []UTXO
+}
+
+// Serialize returns the canonical encoding of the record, see
+// EncodingVersion.
+func (u BlockUndo) Serialize() []byte {
+	return EncodeBlockUndo(u)
+}
+
+// DeserializeBlockUndo decodes an undo record, including records stored as
+// gob by older builds.
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	if isLegacyEncoding(data) {
+		var undo BlockUndo
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+		Handle(err)
+
+		return undo
+	}
+
+	undo, err := DecodeBlockUndo(data)
+	Handle(err)
+
+	return undo
+}
+
+func undoKey(hash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), hash...)
+}
+
+func setBlockUndo(txn *badger.Txn, hash []byte, undo BlockUndo) error {
+	return txn.Set(undoKey(hash), undo.Serialize())
+}
+
+func getBlockUndo(txn *badger.Txn, hash []byte) (BlockUndo, error) {
+	item, err := txn.Get(undoKey(hash))
+	if err != nil {
+		return BlockUndo{}, fmt.Errorf("undo data for block %x not found: %w", hash, err)
+	}
+
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return BlockUndo{}, err
+	}
+
+	return DeserializeBlockUndo(val), nil
+}
+
+// GetBlockUndo returns the undo record of an active chain block.
+func (chain *Blockchain) GetBlockUndo(hash []byte) (BlockUndo, error) {
+	var undo BlockUndo
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		undo, err = getBlockUndo(txn, hash)
+		return err
+	})
+
+	return undo, err
+}
+
+// DisconnectTip removes the active tip: the outputs it spent are restored
+// from its undo record, the outputs it created are deleted and the tip moves
+// back to its parent. The block itself stays stored as a side branch.
+func (chain *Blockchain) DisconnectTip() (*Block, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	return chain.disconnectTip()
+}
@@ -2,23 +2,109 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	"github.com/dgraph-io/badger"
 )
 
 var (
+	// utxoPrefix is the layout used before outputs were keyed by outpoint.
+	// It is only referenced by the migration that removes it.
 	utxoPrefix = []byte("utxo-")
+	coinPrefix = []byte("coin-")
 )
 
+// Outpoint identifies a single transaction output.
+type Outpoint struct {
+	ID    []byte
+	Index int
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.ID, o.Index)
+}
+
+// ParseOutpoint parses an outpoint written by String.
+func ParseOutpoint(s string) (Outpoint, error) {
+	txid, index, ok := strings.Cut(s, ":")
+	if !ok {
+		return Outpoint{}, fmt.Errorf("outpoint %q isnt TXID:INDEX", s)
+	}
+
+	ID, err := hex.DecodeString(txid)
+	if err != nil {
+		return Outpoint{}, fmt.Errorf("outpoint %q: %w", s, err)
+	}
+
+	idx, err := strconv.Atoi(index)
+	if err != nil || idx < 0 {
+		return Outpoint{}, fmt.Errorf("outpoint %q has no valid output index", s)
+	}
+
+	return Outpoint{ID: ID, Index: idx}, nil
+}
+
+// Key is the UTXO set key of the outpoint: prefix, txid and big endian
+// output index, so all outputs of a transaction are stored next to each other.
+func (o Outpoint) Key() []byte {
+	key := make([]byte, 0, len(coinPrefix)+len(o.ID)+4)
+	key = append(key, coinPrefix...)
+	key = append(key, o.ID...)
+
+	return binary.BigEndian.AppendUint32(key, uint32(o.Index))
+}
+
+// UTXO is an unspent output together with the height of the block that
+// created it and whether it was created by a coinbase transaction.
+type UTXO struct {
+	Outpoint Outpoint
+	Output   TxOutput
+	Height   int
+	Coinbase bool
+}
+
+// IsMature reports whether the output can be spent by a transaction in a
+// block at spendHeight.
+func (u UTXO) IsMature(spendHeight, maturity int) bool {
+	return !u.Coinbase || spendHeight-u.Height >= maturity
+}
+
+// Serialize returns the canonical encoding of the output, see
+// EncodingVersion.
+func (u UTXO) Serialize() []byte {
+	return EncodeUTXO(u)
+}
+
+// DeserializeUTXO decodes an unspent output. Outputs stored as gob by older
+// builds are still read, so that migrations written before the canonical
+// encoding keep working.
+func DeserializeUTXO(data []byte) UTXO {
+	if isLegacyEncoding(data) {
+		var u UTXO
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&u)
+		Handle(err)
+
+		return u
+	}
+
+	u, err := DecodeUTXO(data)
+	Handle(err)
+
+	return u
+}
+
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
+// forEach calls callback for every unspent output in the set.
+func (u UTXOSet) forEach(callback func(utxo UTXO)) {
 	db := u.Blockchain.Database
 
 	err := db.View(func(txn *badger.Txn) error {
@@ -27,83 +113,116 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-
-			v, err := item.ValueCopy(nil)
+		for it.Seek(coinPrefix); it.ValidForPrefix(coinPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
 			Handle(err)
 
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-
-			for outIdx, out := range outs.Outputs {
-				// Could this be changed to return all outs?
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
-			}
+			callback(DeserializeUTXO(v))
 		}
+
 		return nil
 	})
 	Handle(err)
+}
+
+// FindSpendableOutputs selects outputs of pubKeyHash worth at least amount.
+// Immature coinbase outputs are left out since the next block couldn't
+// include a transaction spending them.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	return u.FindSpendableScriptOutputs(PayToPubKeyHashScript(pubKeyHash), amount)
+}
+
+// FindSpendableScriptOutputs selects outputs locked by lockingScript worth
+// at least amount, like FindSpendableOutputs.
+func (u UTXOSet) FindSpendableScriptOutputs(lockingScript []byte, amount int) (int, map[string][]int) {
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+	spendHeight := u.Blockchain.GetBestHeight() + 1
+	maturity := u.Blockchain.Policy.CoinbaseMaturity
+
+	u.forEach(func(utxo UTXO) {
+		if !utxo.IsMature(spendHeight, maturity) {
+			return
+		}
+
+		if bytes.Equal(utxo.Output.LockingScript(), lockingScript) && accumulated < amount {
+			txID := hex.EncodeToString(utxo.Outpoint.ID)
+
+			accumulated += utxo.Output.Value
+			unspentOuts[txID] = append(unspentOuts[txID], utxo.Outpoint.Index)
+		}
+	})
+
 	return accumulated, unspentOuts
 }
 
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	var UTXOs []TxOutput
 
-	db := u.Blockchain.Database
+	u.forEach(func(utxo UTXO) {
+		if utxo.Output.IsLockedWithKey(pubKeyHash) {
+			UTXOs = append(UTXOs, utxo.Output)
+		}
+	})
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	return UTXOs
+}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+// Balance sums the outputs locked by lockingScript. Coinbase outputs that
+// can't be spent in the next block yet are counted as immature.
+func (u UTXOSet) Balance(lockingScript []byte) (spendable, immature int) {
+	spendHeight := u.Blockchain.GetBestHeight() + 1
+	maturity := u.Blockchain.Policy.CoinbaseMaturity
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			var v []byte
-			err := item.Value(func(val []byte) error {
-				v = val
-				return nil
-			})
-			Handle(err)
-			outs := DeserializeOutputs(v)
+	u.forEach(func(utxo UTXO) {
+		if !bytes.Equal(utxo.Output.LockingScript(), lockingScript) {
+			return
+		}
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
+		if utxo.IsMature(spendHeight, maturity) {
+			spendable += utxo.Output.Value
+		} else {
+			immature += utxo.Output.Value
 		}
+	})
+
+	return spendable, immature
+}
+
+// GetUTXO returns the unspent output at outpoint.
+func (u UTXOSet) GetUTXO(outpoint Outpoint) (UTXO, error) {
+	var utxo UTXO
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(outpoint.Key())
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		utxo = DeserializeUTXO(v)
 
 		return nil
 	})
-	Handle(err)
 
-	return UTXOs
+	return utxo, err
 }
 
+// CountTransactions returns the number of transactions with at least one
+// unspent output.
 func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
 	counter := 0
+	var lastID []byte
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+	u.forEach(func(utxo UTXO) {
+		if !bytes.Equal(lastID, utxo.Outpoint.ID) {
 			counter++
+			lastID = utxo.Outpoint.ID
 		}
-
-		return nil
 	})
-	Handle(err)
 
 	return counter
 }
@@ -111,17 +230,13 @@ func (u UTXOSet) CountTransactions() int {
 func (u UTXOSet) Reindex() {
 	db := u.Blockchain.Database
 
-	u.DeleteByPrefix(utxoPrefix)
+	u.DeleteByPrefix(coinPrefix)
 
-	UTXO := u.Blockchain.FindUTXO()
+	UTXOs := u.Blockchain.FindUTXO()
 
 	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			Handle(err)
-			key = append(utxoPrefix, key...)
-
-			err = txn.Set(key, outs.Serialize())
+		for _, utxo := range UTXOs {
+			err := txn.Set(utxo.Outpoint.Key(), utxo.Serialize())
 			Handle(err)
 		}
 		return nil
@@ -129,62 +244,165 @@ func (u UTXOSet) Reindex() {
 	Handle(err)
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
+// connectBlock validates the transactions of block against the UTXO set
+// and applies them inside txn, so the UTXO set changes together with the
+// active tip. Transactions may spend outputs created earlier in the same
+// block. The spent outputs are stored as the block's undo record.
+func (u UTXOSet) connectBlock(txn *badger.Txn, block *Block) error {
+	undo := BlockUndo{}
+	spentInBlock := make(map[string]bool)
+	fees := 0
+
+	// Lock times are compared to the median time past of the parent, the
+	// genesis block has nothing but its coinbase to check.
+	var spendTime int64
+	if len(block.PrevHash) != 0 {
+		parent, err := u.Blockchain.GetBlock(block.PrevHash)
+		if err != nil {
+			return err
+		}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					Handle(err)
-					var v []byte
-					err = item.Value(func(val []byte) error {
-						v = val
-						return nil
-					})
-					Handle(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
+		spendTime, err = u.Blockchain.MedianTimePast(&parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			spent, fee, err := u.Blockchain.checkTransactionInputs(txn, tx, block.Height, spendTime, spentInBlock)
+			if err != nil {
+				return err
+			}
+
+			for _, utxo := range spent {
+				if err := txn.Delete(utxo.Outpoint.Key()); err != nil {
+					return err
 				}
 			}
 
-			newOutputs := TxOutputs{}
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Outputs...)
+			undo.Spent = append(undo.Spent, spent...)
+			fees += fee
+		}
 
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+		for outIdx, out := range tx.Outputs {
+			if IsUnspendable(out.LockingScript()) {
+				continue
+			}
+
+			utxo := UTXO{
+				Outpoint: Outpoint{ID: tx.ID, Index: outIdx},
+				Output:   out,
+				Height:   block.Height,
+				Coinbase: tx.IsCoinbase(),
+			}
+
+			if _, err := txn.Get(utxo.Outpoint.Key()); err == nil {
+				return ruleError(RejectDuplicateTx, tx.GetID(), "output %s already exists", utxo.Outpoint)
+			}
+
+			if err := txn.Set(utxo.Outpoint.Key(), utxo.Serialize()); err != nil {
+				return err
 			}
 		}
+	}
 
-		return nil
-	})
-	Handle(err)
+	if err := checkCoinbase(block, u.Blockchain.Policy.Subsidy(block.Height), fees); err != nil {
+		return err
+	}
+
+	return setBlockUndo(txn, block.Hash, undo)
+}
+
+// reconnectBlock applies a block that was connected before without
+// validating its transactions again, recording the outputs it spends as
+// its undo record.
+func (u UTXOSet) reconnectBlock(txn *badger.Txn, block *Block) error {
+	undo := BlockUndo{}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+				item, err := txn.Get(outpoint.Key())
+				if err != nil {
+					return fmt.Errorf("output %s spent by %s not found: %w", outpoint, tx.GetID(), err)
+				}
+
+				v, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+
+				if err := txn.Delete(outpoint.Key()); err != nil {
+					return err
+				}
+				undo.Spent = append(undo.Spent, DeserializeUTXO(v))
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			if IsUnspendable(out.LockingScript()) {
+				continue
+			}
+
+			utxo := UTXO{
+				Outpoint: Outpoint{ID: tx.ID, Index: outIdx},
+				Output:   out,
+				Height:   block.Height,
+				Coinbase: tx.IsCoinbase(),
+			}
+
+			if err := txn.Set(utxo.Outpoint.Key(), utxo.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return setBlockUndo(txn, block.Hash, undo)
+}
+
+// disconnectBlock reverts connectBlock for the active tip inside txn using
+// the block's undo record.
+func (u UTXOSet) disconnectBlock(txn *badger.Txn, block *Block) error {
+	undo, err := getBlockUndo(txn, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		for outIdx := range tx.Outputs {
+			if err := txn.Delete(Outpoint{ID: tx.ID, Index: outIdx}.Key()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Outputs created and spent inside the same block didn't exist before
+	// it, so they aren't restored.
+	for i := len(undo.Spent) - 1; i >= 0; i-- {
+		utxo := undo.Spent[i]
+
+		if utxo.Height == block.Height {
+			continue
+		}
+
+		if err := txn.Set(utxo.Outpoint.Key(), utxo.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return txn.Delete(undoKey(block.Hash))
 }
 
 func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+	deleteByPrefix(u.Blockchain.Database, prefix)
+}
+
+func deleteByPrefix(db *badger.DB, prefix []byte) {
 	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		if err := db.Update(func(txn *badger.Txn) error {
 			for _, key := range keysForDelete {
 				if err := txn.Delete(key); err != nil {
 					return err
@@ -199,7 +417,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 
 	collectSize := 100000
 
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+	db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
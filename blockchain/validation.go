@@ -0,0 +1,305 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// RejectReason tells why a block or transaction failed validation.
+type RejectReason string
+
+const (
+	RejectBadPrevHash      RejectReason = "bad_prev_hash"
+	RejectBadHeight        RejectReason = "bad_height"
+	RejectBadBits          RejectReason = "bad_bits"
+	RejectTimeTooOld       RejectReason = "time_too_old"
+	RejectTimeTooNew       RejectReason = "time_too_new"
+	RejectBadPoW           RejectReason = "bad_pow"
+	RejectBadSeal          RejectReason = "bad_seal"
+	RejectBadVersion       RejectReason = "bad_version"
+	RejectBadMerkleRoot    RejectReason = "bad_merkle_root"
+	RejectBadHash          RejectReason = "bad_hash"
+	RejectBadCoinbase      RejectReason = "bad_coinbase"
+	RejectBadCoinbaseValue RejectReason = "bad_coinbase_value"
+	RejectDuplicateTx      RejectReason = "duplicate_tx"
+	RejectEmptyTx          RejectReason = "empty_tx"
+	RejectBadTxVersion     RejectReason = "bad_tx_version"
+	RejectBadTxID          RejectReason = "bad_txid"
+	RejectBadOutputValue   RejectReason = "bad_output_value"
+	RejectMissingInputs    RejectReason = "missing_inputs"
+	RejectDoubleSpend      RejectReason = "double_spend"
+	RejectValueNotBalanced RejectReason = "outputs_exceed_inputs"
+	RejectBadScript        RejectReason = "bad_script"
+	RejectScriptFailed     RejectReason = "script_failed"
+	RejectImmatureSpend    RejectReason = "immature_coinbase_spend"
+	RejectNonFinal         RejectReason = "non_final"
+	RejectSequenceLock     RejectReason = "sequence_locked"
+)
+
+// ValidationError is returned when a block or transaction breaks a
+// consensus rule. Hash identifies the offending block or transaction.
+type ValidationError struct {
+	Reason RejectReason
+	Hash   string
+	Msg    string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Reason, e.Hash, e.Msg)
+}
+
+func ruleError(reason RejectReason, hash string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Reason: reason,
+		Hash:   hash,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+// RejectReasonOf extracts the reject reason from err, if it is a
+// validation error.
+func RejectReasonOf(err error) (RejectReason, bool) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Reason, true
+	}
+
+	return "", false
+}
+
+// isPermanentRejection reports whether err means the block can never be
+// valid. A timestamp too far ahead of the clock becomes valid later, and a
+// block whose contents don't match its hash may have been damaged on the
+// way, so the real block can still arrive under that hash.
+func isPermanentRejection(err error) bool {
+	reason, ok := RejectReasonOf(err)
+	if !ok {
+		return false
+	}
+
+	switch reason {
+	case RejectTimeTooNew, RejectBadHash, RejectBadMerkleRoot, RejectBadTxID:
+		return false
+	}
+
+	return true
+}
+
+// checkTransactionSanity runs the checks that don't need chain state.
+func checkTransactionSanity(tx *Transaction) error {
+	if tx.Version < TxVersion {
+		return ruleError(RejectBadTxVersion, tx.GetID(), "transaction version %d is below %d", tx.Version, TxVersion)
+	}
+
+	if id := tx.Hash(); !bytes.Equal(tx.ID, id) {
+		return ruleError(RejectBadTxID, tx.GetID(), "id doesnt match transaction hash %x", id)
+	}
+
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return ruleError(RejectEmptyTx, tx.GetID(), "transaction has %d inputs and %d outputs", len(tx.Inputs), len(tx.Outputs))
+	}
+
+	total, dataOutputs := 0, 0
+	for idx, out := range tx.Outputs {
+		if out.Value < 0 {
+			return ruleError(RejectBadOutputValue, tx.GetID(), "output %d has negative value %d", idx, out.Value)
+		}
+
+		total += out.Value
+		if total < 0 {
+			return ruleError(RejectBadOutputValue, tx.GetID(), "output values overflow")
+		}
+
+		if len(out.ScriptPubKey) > MaxScriptSize {
+			return ruleError(RejectBadScript, tx.GetID(), "output %d script has %d bytes, max %d", idx, len(out.ScriptPubKey), MaxScriptSize)
+		}
+
+		// Outputs starting with OP_RETURN never enter the UTXO set, so they
+		// are only allowed as null data outputs of limited size.
+		if len(out.ScriptPubKey) > 0 && out.ScriptPubKey[0] == OpReturn {
+			data, ok := ExtractNullData(out.ScriptPubKey)
+			if !ok {
+				return ruleError(RejectBadScript, tx.GetID(), "output %d starts with OP_RETURN but isnt a null data script", idx)
+			}
+			if len(data) > MaxDataCarrierSize {
+				return ruleError(RejectBadScript, tx.GetID(), "output %d carries %d bytes, max %d", idx, len(data), MaxDataCarrierSize)
+			}
+			dataOutputs++
+		}
+	}
+
+	if dataOutputs > 1 {
+		return ruleError(RejectBadScript, tx.GetID(), "transaction has %d data outputs, max 1", dataOutputs)
+	}
+
+	for idx, in := range tx.Inputs {
+		if !tx.IsCoinbase() && !IsPushOnly(in.ScriptSig) {
+			return ruleError(RejectBadScript, tx.GetID(), "input %d unlocking script is not push only", idx)
+		}
+	}
+
+	return nil
+}
+
+// checkTransactionInputs validates a non-coinbase transaction included at
+// spendHeight, in a block whose parent has the median time past spendTime,
+// against the UTXO set as seen by txn: the transaction has to be final,
+// every input has to spend an existing, mature output that isn't spent by
+// an earlier transaction of the same block and whose relative lock has
+// passed, the outputs can't be worth more than the inputs and every input
+// script has to succeed. It returns the spent outputs and the transaction
+// fee.
+func (chain *Blockchain) checkTransactionInputs(txn *badger.Txn, tx *Transaction, spendHeight int, spendTime int64, spentInBlock map[string]bool) ([]UTXO, int, error) {
+	if err := checkTransactionSanity(tx); err != nil {
+		return nil, 0, err
+	}
+
+	if !tx.IsFinal(spendHeight, spendTime) {
+		return nil, 0, ruleError(RejectNonFinal, tx.GetID(), "lock time %d not reached at height %d and median time %d", tx.LockTime, spendHeight, spendTime)
+	}
+
+	var spent []UTXO
+	prevOuts := make(map[string]TxOutput)
+	inputValue := 0
+
+	for _, in := range tx.Inputs {
+		outpoint := Outpoint{ID: in.ID, Index: in.Out}
+
+		if spentInBlock[outpoint.String()] {
+			return nil, 0, ruleError(RejectDoubleSpend, tx.GetID(), "output %s is already spent", outpoint)
+		}
+
+		item, err := txn.Get(outpoint.Key())
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, 0, ruleError(RejectMissingInputs, tx.GetID(), "output %s is not in utxo set", outpoint)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		utxo := DeserializeUTXO(v)
+
+		if maturity := chain.Policy.CoinbaseMaturity; !utxo.IsMature(spendHeight, maturity) {
+			return nil, 0, ruleError(RejectImmatureSpend, tx.GetID(), "coinbase output %s from height %d can't be spent before height %d", outpoint, utxo.Height, utxo.Height+maturity)
+		}
+
+		if err := chain.checkSequenceLock(in, utxo, spendHeight, spendTime); err != nil {
+			if !errors.Is(err, ErrUnsatisfiedLock) {
+				return nil, 0, err
+			}
+			return nil, 0, ruleError(RejectSequenceLock, tx.GetID(), "input %s: %v", outpoint, err)
+		}
+
+		spentInBlock[outpoint.String()] = true
+		spent = append(spent, utxo)
+		prevOuts[outpoint.String()] = utxo.Output
+		inputValue += utxo.Output.Value
+	}
+
+	outputValue := 0
+	for _, out := range tx.Outputs {
+		outputValue += out.Value
+	}
+
+	if outputValue > inputValue {
+		return nil, 0, ruleError(RejectValueNotBalanced, tx.GetID(), "outputs worth %d exceed inputs worth %d", outputValue, inputValue)
+	}
+
+	if err := tx.Verify(prevOuts, chain.ChainID()); err != nil {
+		return nil, 0, ruleError(RejectScriptFailed, tx.GetID(), "script verification failed: %v", err)
+	}
+
+	return spent, inputValue - outputValue, nil
+}
+
+// checkCoinbase makes sure a block has exactly one coinbase transaction and
+// that it doesn't claim more than the subsidy plus the fees of the block.
+func checkCoinbase(block *Block, subsidy, fees int) error {
+	var coinbase *Transaction
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			continue
+		}
+
+		if coinbase != nil {
+			return ruleError(RejectBadCoinbase, block.GetHash(), "block has more than one coinbase transaction")
+		}
+		coinbase = tx
+	}
+
+	if coinbase == nil {
+		return ruleError(RejectBadCoinbase, block.GetHash(), "block has no coinbase transaction")
+	}
+
+	if err := checkTransactionSanity(coinbase); err != nil {
+		return err
+	}
+
+	claimed := 0
+	for _, out := range coinbase.Outputs {
+		claimed += out.Value
+	}
+
+	if allowed := subsidy + fees; claimed > allowed {
+		return ruleError(RejectBadCoinbaseValue, block.GetHash(), "coinbase claims %d, allowed %d", claimed, allowed)
+	}
+
+	return nil
+}
+
+// CheckTransaction validates a transaction that isn't in a block yet
+// against the current UTXO set, as if it was included in the next block.
+func (chain *Blockchain) CheckTransaction(tx *Transaction) error {
+	_, err := chain.TransactionFee(tx)
+	return err
+}
+
+// TransactionFee validates tx like CheckTransaction and returns the value
+// of its inputs that isn't claimed by its outputs.
+func (chain *Blockchain) TransactionFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, ruleError(RejectBadCoinbase, tx.GetID(), "coinbase transaction outside of a block")
+	}
+
+	fee := 0
+
+	tip, err := chain.GetLastBlock()
+	if err != nil {
+		return 0, err
+	}
+
+	spendTime, err := chain.MedianTimePast(tip)
+	if err != nil {
+		return 0, err
+	}
+
+	err = chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		_, fee, err = chain.checkTransactionInputs(txn, tx, tip.Height+1, spendTime, make(map[string]bool))
+		return err
+	})
+
+	return fee, err
+}
+
+func (chain *Blockchain) logRejectedBlock(block *Block, err error) {
+	reason, ok := RejectReasonOf(err)
+	if !ok {
+		reason = "internal_error"
+	}
+
+	chain.Logger.Warnw("block_rejected",
+		"hash", block.GetHash(),
+		"height", block.Height,
+		"reason", reason,
+		"error", err,
+	)
+}
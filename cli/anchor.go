@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	anchorCmd = &cobra.Command{
+		Use:   "anchor",
+		Short: "Anchors data on chain",
+		Long:  `anchor -from FROM -data HEX|FILE [-fee FEE] - Stores up to 80 bytes of hex data, or the sha256 of a file, in an unspendable output. find-anchor tells which block anchored it.`,
+		Run:   anchor,
+	}
+)
+
+// anchorData returns the hex decoded data, or the sha256 of the file named
+// by data if it isn't hex.
+func anchorData(data string) []byte {
+	if decoded, err := hex.DecodeString(data); err == nil && len(decoded) > 0 {
+		return decoded
+	}
+
+	content, err := os.ReadFile(data)
+	if err != nil {
+		log.Panic("Data is neither hex nor a readable file: ", err)
+	}
+
+	hash := sha256.Sum256(content)
+
+	return hash[:]
+}
+
+func anchor(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetString("from")
+	dataFlag, _ := cmd.Flags().GetString("data")
+	fee, _ := cmd.Flags().GetInt("fee")
+
+	if !wallet.ValidateAddress(from) {
+		log.Panic("Address not valid")
+	}
+
+	data := anchorData(dataFlag)
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.Load(nodeID)
+	if err != nil {
+		chain.Logger.Panicw("error_loading_wallets",
+			"error", err,
+		)
+	}
+	w := wallets.GetWallet(from)
+
+	tx := blockchain.NewDataTransaction(&w, data, fee, &UTXOSet)
+
+	client := network.NewClient(chain.Logger, fmt.Sprintf("localhost:%s", nodeID))
+	client.SendTx(params.Active().DefaultNode(), tx)
+
+	chain.Logger.Infow("anchor_tx_sent_to_founding_node",
+		"tx_id", tx.GetID(),
+		"data", fmt.Sprintf("%x", data),
+	)
+
+	fmt.Printf("Anchoring %x in transaction %s\n", data, tx.GetID())
+}
@@ -3,7 +3,11 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"github.com/spf13/cobra"
 )
 
@@ -11,12 +15,38 @@ var (
 	nodeID string
 
 	rootCmd = &cobra.Command{
-		Use:   "chain-cli",
-		Short: "UTXO based blockchain demo application",
-		Long:  `UTXO based blockchain demo application`,
+		Use:               "chain-cli",
+		Short:             "UTXO based blockchain demo application",
+		Long:              `UTXO based blockchain demo application`,
+		PersistentPreRunE: selectNetwork,
 	}
 )
 
+// selectNetwork applies the network flag. Nodes without NODE_ID use the
+// default port of the network.
+func selectNetwork(cmd *cobra.Command, args []string) error {
+	network, _ := cmd.Flags().GetString("network")
+	if err := params.Select(network); err != nil {
+		return err
+	}
+
+	if nodeID == "" {
+		nodeID = params.Active().DefaultPort
+	}
+
+	return nil
+}
+
+// nodeAddress returns the node address given by flag, or the node on the
+// default port of the network.
+func nodeAddress(cmd *cobra.Command, flag string) string {
+	if addr, _ := cmd.Flags().GetString(flag); addr != "" {
+		return addr
+	}
+
+	return params.Active().DefaultNode()
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -27,12 +57,20 @@ func Execute() {
 func init() {
 	nodeID = os.Getenv("NODE_ID")
 
+	rootCmd.PersistentFlags().String("network", params.MainNet.Name, "Network to use: "+strings.Join(params.Names(), ", "))
+
 	getBalanceCmd.Flags().StringP("addr", "a", "", "Specify the address for balance")
 	getBalanceCmd.MarkFlagRequired("addr")
 	rootCmd.AddCommand(getBalanceCmd)
 
 	createChainCmd.Flags().StringP("addr", "a", "", "Specify the address for block reward")
 	createChainCmd.MarkFlagRequired("addr")
+	createChainCmd.Flags().Int("reward", 0, "Initial block subsidy, defaults to the network setting")
+	createChainCmd.Flags().Int("halving-interval", 0, "Blocks between subsidy halvings, 0 never halves, defaults to the network setting")
+	createChainCmd.Flags().Int("max-supply", 0, "Cap on the total issued supply, 0 means no cap, defaults to the network setting")
+	createChainCmd.Flags().Int("coinbase-maturity", 0, "Blocks before mining rewards can be spent, defaults to the network setting")
+	createChainCmd.Flags().String("consensus", blockchain.DefaultConsensusConfig.Engine, "Consensus engine, pow or poa")
+	createChainCmd.Flags().StringSlice("validators", nil, "Hex public keys signing proof of authority blocks, in turn order")
 	rootCmd.AddCommand(createChainCmd)
 
 	sendCmd.Flags().StringP("from", "f", "", "Specify the from address")
@@ -41,14 +79,116 @@ func init() {
 	sendCmd.MarkFlagRequired("to")
 	sendCmd.Flags().IntP("amount", "a", 5, "Specify amount")
 	sendCmd.MarkFlagRequired("amount")
+	sendCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	sendCmd.Flags().Int("fee-rate", 0, "Fee paid to the miner per byte of the transaction")
+	sendCmd.Flags().Uint32("lock-until", 0, "Height, or unix time from 500000000 on, before which the coins cant be claimed")
+	sendCmd.Flags().Uint16("lock-blocks", 0, "Blocks after mining before the coins can be claimed")
 	sendCmd.Flags().BoolP("mine", "m", false, "Mine now")
 	rootCmd.AddCommand(sendCmd)
 
 	startNodeCmd.Flags().StringP("miner", "m", "", "Specify the address for mining rewards")
+	startNodeCmd.Flags().String("signer", "", "Wallet address whose key signs proof of authority blocks, defaults to the miner address")
+	startNodeCmd.Flags().Int("miner-workers", 0, "Goroutines searching for nonces, defaults to the number of cores")
+	startNodeCmd.Flags().Bool("txindex", false, "Maintain the transaction index")
+	startNodeCmd.Flags().String("seed", "", "Node to connect to first, its own address starts a separate network, defaults to the network port")
 	rootCmd.AddCommand(startNodeCmd)
 
+	printChainCmd.Flags().Int("from", -1, "Print active chain blocks starting at this height")
+	printChainCmd.Flags().Int("to", -1, "Print active chain blocks up to this height")
 	rootCmd.AddCommand(printChainCmd)
+	listAddressesCmd.Flags().Bool("pubkeys", false, "Also print the public key of every address")
 	rootCmd.AddCommand(listAddressesCmd)
+	reindexUTXOCmd.Flags().Bool("tx", false, "Also rebuild and enable the transaction index")
 	rootCmd.AddCommand(reindexUTXOCmd)
 	rootCmd.AddCommand(createWalletCmd)
+	rootCmd.AddCommand(getTransactionCmd)
+
+	rollbackCmd.Flags().Int("to-height", -1, "Height of the new tip")
+	rollbackCmd.MarkFlagRequired("to-height")
+	rollbackCmd.Flags().Bool("invalidate", false, "Mark the first disconnected block invalid")
+	rootCmd.AddCommand(rollbackCmd)
+
+	supplyCmd.Flags().Int("height", -1, "Height to report the supply at, defaults to the tip")
+	rootCmd.AddCommand(supplyCmd)
+
+	verifyTxCmd.Flags().String("peer", "", "Node to request the merkle proof from, defaults to the network port")
+	verifyTxCmd.Flags().Duration("timeout", 10*time.Second, "How long to wait for the merkle proof")
+	verifyTxCmd.Flags().StringSlice("validators", nil, "Hex public keys of the validators of a proof of authority chain")
+	rootCmd.AddCommand(verifyTxCmd)
+
+	createMultisigCmd.Flags().Int("required", 2, "Signatures needed to spend")
+	createMultisigCmd.Flags().StringSlice("pubkeys", nil, "Hex public keys of the signers")
+	createMultisigCmd.MarkFlagRequired("pubkeys")
+	rootCmd.AddCommand(createMultisigCmd)
+
+	multisigTxCmd.Flags().StringP("from", "f", "", "Multisig address to spend from")
+	multisigTxCmd.MarkFlagRequired("from")
+	multisigTxCmd.Flags().StringP("to", "t", "", "Specify the target address")
+	multisigTxCmd.MarkFlagRequired("to")
+	multisigTxCmd.Flags().IntP("amount", "a", 5, "Specify amount")
+	multisigTxCmd.MarkFlagRequired("amount")
+	multisigTxCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	multisigTxCmd.Flags().String("redeem-script", "", "Hex redeem script, if the address isnt in the wallet file")
+	multisigTxCmd.Flags().String("out", "multisig_tx.json", "File to write the unsigned transaction to")
+	rootCmd.AddCommand(multisigTxCmd)
+
+	multisigSignCmd.Flags().String("file", "multisig_tx.json", "Transaction file written by multisig-tx")
+	multisigSignCmd.Flags().StringSlice("wallets", nil, "Node IDs whose wallet files to sign with, defaults to NODE_ID")
+	rootCmd.AddCommand(multisigSignCmd)
+
+	multisigFinalizeCmd.Flags().String("file", "multisig_tx.json", "Signed transaction file")
+	rootCmd.AddCommand(multisigFinalizeCmd)
+
+	claimCmd.Flags().StringP("addr", "a", "", "Address the coins were locked to")
+	claimCmd.MarkFlagRequired("addr")
+	claimCmd.Flags().StringP("to", "t", "", "Address to send the coins to, defaults to addr")
+	claimCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	rootCmd.AddCommand(claimCmd)
+
+	htlcFundCmd.Flags().StringP("from", "f", "", "Address funding the contract and taking refunds")
+	htlcFundCmd.MarkFlagRequired("from")
+	htlcFundCmd.Flags().StringP("to", "t", "", "Address that can claim with the secret")
+	htlcFundCmd.MarkFlagRequired("to")
+	htlcFundCmd.Flags().IntP("amount", "a", 5, "Specify amount")
+	htlcFundCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	htlcFundCmd.Flags().Uint32("lock-until", 0, "Height, or unix time from 500000000 on, after which the funder can refund")
+	htlcFundCmd.Flags().Uint32("lock-blocks", 0, "Blocks from the tip after which the funder can refund")
+	htlcFundCmd.Flags().String("secret-hash", "", "Hex sha256 of the secret, generates a new secret if empty")
+	htlcCmd.AddCommand(htlcFundCmd)
+
+	htlcClaimCmd.Flags().StringP("addr", "a", "", "Address the contracts pay to")
+	htlcClaimCmd.MarkFlagRequired("addr")
+	htlcClaimCmd.Flags().String("secret", "", "Hex secret of the contracts")
+	htlcClaimCmd.MarkFlagRequired("secret")
+	htlcClaimCmd.Flags().StringP("to", "t", "", "Address to send the coins to, defaults to addr")
+	htlcClaimCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	htlcCmd.AddCommand(htlcClaimCmd)
+
+	htlcRefundCmd.Flags().StringP("addr", "a", "", "Address that funded the contracts")
+	htlcRefundCmd.MarkFlagRequired("addr")
+	htlcRefundCmd.Flags().StringP("to", "t", "", "Address to send the coins to, defaults to addr")
+	htlcRefundCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	htlcCmd.AddCommand(htlcRefundCmd)
+
+	htlcListCmd.Flags().StringP("addr", "a", "", "Specify the address")
+	htlcListCmd.MarkFlagRequired("addr")
+	htlcCmd.AddCommand(htlcListCmd)
+
+	htlcSecretCmd.Flags().String("contract", "", "Contract you funded, as TXID:INDEX")
+	htlcSecretCmd.MarkFlagRequired("contract")
+	htlcCmd.AddCommand(htlcSecretCmd)
+
+	htlcCmd.PersistentFlags().String("node", "", "Node to send transactions to, defaults to the network port")
+	rootCmd.AddCommand(htlcCmd)
+
+	anchorCmd.Flags().StringP("from", "f", "", "Address paying the fee")
+	anchorCmd.MarkFlagRequired("from")
+	anchorCmd.Flags().String("data", "", "Hex data, or a file whose sha256 is anchored")
+	anchorCmd.MarkFlagRequired("data")
+	anchorCmd.Flags().Int("fee", 0, "Flat fee paid to the miner")
+	rootCmd.AddCommand(anchorCmd)
+
+	findAnchorCmd.Flags().String("data", "", "Hex data, or a file whose sha256 was anchored")
+	findAnchorCmd.MarkFlagRequired("data")
+	rootCmd.AddCommand(findAnchorCmd)
 }
@@ -29,14 +29,25 @@ func getBalance(cmd *cobra.Command, args []string) {
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
-	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance += out.Value
+	lockingScript, err := blockchain.AddressScript(address)
+	if err != nil {
+		log.Panic(err)
 	}
+	balance, immature := UTXOSet.Balance(lockingScript)
 
 	fmt.Printf("Balance of %s: %d\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature mining rewards: %d\n", immature)
+	}
+
+	if version, pubKeyHash, _ := wallet.DecodeAddress(address); version == wallet.PubKeyHashVersion() {
+		locked := 0
+		for _, utxo := range UTXOSet.FindTimeLocked(pubKeyHash) {
+			locked += utxo.Output.Value
+		}
+
+		if locked > 0 {
+			fmt.Printf("Time locked, see claim: %d\n", locked)
+		}
+	}
 }
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	claimCmd = &cobra.Command{
+		Use:   "claim",
+		Short: "Claims time locked coins",
+		Long:  `claim -addr ADDR [-to TO] [-fee FEE] - Sends every time locked output of ADDR whose lock has passed to TO, or back to ADDR.`,
+		Run:   claim,
+	}
+)
+
+func claim(cmd *cobra.Command, args []string) {
+	address, _ := cmd.Flags().GetString("addr")
+	to, _ := cmd.Flags().GetString("to")
+	fee, _ := cmd.Flags().GetInt("fee")
+
+	if to == "" {
+		to = address
+	}
+
+	if !wallet.ValidateAddress(address) || !wallet.ValidateAddress(to) {
+		log.Panic("Address not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.Load(nodeID)
+	if err != nil {
+		chain.Logger.Panicw("error_loading_wallets",
+			"error", err,
+		)
+	}
+	w := wallets.GetWallet(address)
+
+	tx := blockchain.NewClaimTransaction(&w, to, fee, &UTXOSet)
+
+	client := network.NewClient(chain.Logger, fmt.Sprintf("localhost:%s", nodeID))
+	client.SendTx(params.Active().DefaultNode(), tx)
+
+	chain.Logger.Infow("claim_tx_sent_to_founding_node",
+		"tx_id", tx.GetID(),
+		"addr", address,
+		"to_addr", to,
+		"lock_time", tx.LockTime,
+	)
+
+	fmt.Printf("Transaction %s sent\n", tx.GetID())
+}
@@ -1,10 +1,12 @@
 package cli
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"github.com/aadejanovs/blockchain-demo/wallet"
 	"github.com/spf13/cobra"
 )
@@ -13,7 +15,7 @@ var (
 	createChainCmd = &cobra.Command{
 		Use:   "create",
 		Short: "Creates new blockchain",
-		Long:  `creates new blockchain and sends genesis reward to address`,
+		Long:  `creates new blockchain and sends genesis reward to address. --consensus poa creates a proof of authority chain whose blocks are signed by the --validators public keys, see addr --pubkeys.`,
 		Run:   createChain,
 	}
 )
@@ -24,10 +26,40 @@ func createChain(cmd *cobra.Command, args []string) {
 		log.Panic("Address not valid")
 	}
 
-	chain := blockchain.InitBlockchain(address, nodeID)
+	// The network settings apply unless a flag is given.
+	policy := blockchain.NetworkMonetaryPolicy(params.Active())
+	for flag, value := range map[string]*int{
+		"reward":            &policy.InitialSubsidy,
+		"halving-interval":  &policy.HalvingInterval,
+		"max-supply":        &policy.MaxSupply,
+		"coinbase-maturity": &policy.CoinbaseMaturity,
+	} {
+		if cmd.Flags().Changed(flag) {
+			*value, _ = cmd.Flags().GetInt(flag)
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		log.Panic(err)
+	}
+
+	consensus := blockchain.ConsensusConfig{}
+	consensus.Engine, _ = cmd.Flags().GetString("consensus")
+
+	validators, _ := cmd.Flags().GetStringSlice("validators")
+	for _, validator := range validators {
+		pubKey, err := hex.DecodeString(validator)
+		if err != nil {
+			log.Panicf("Validator key %s not valid", validator)
+		}
+		consensus.Validators = append(consensus.Validators, pubKey)
+	}
+
+	if err := consensus.Validate(); err != nil {
+		log.Panic(err)
+	}
 
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
+	chain := blockchain.InitBlockchain(address, nodeID, policy, consensus)
 
 	fmt.Println("Finished!")
 	chain.Database.Close()
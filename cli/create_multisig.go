@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	createMultisigCmd = &cobra.Command{
+		Use:   "create-multisig",
+		Short: "Creates a multisig address",
+		Long:  `create-multisig -required M -pubkeys KEY1,KEY2,... - Creates an address whose funds need M signatures of the given hex public keys, see addr -pubkeys. The address is stored in the node wallet file.`,
+		Run:   createMultisig,
+	}
+)
+
+func createMultisig(cmd *cobra.Command, args []string) {
+	required, _ := cmd.Flags().GetInt("required")
+	hexKeys, _ := cmd.Flags().GetStringSlice("pubkeys")
+
+	var pubKeys [][]byte
+	for _, hexKey := range hexKeys {
+		pubKey, err := hex.DecodeString(hexKey)
+		if err != nil {
+			log.Panic("Public key not valid")
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	redeemScript, err := blockchain.NewMultisigScript(required, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, _ := wallet.Load(nodeID)
+	address := wallets.AddMultisig(wallet.MultisigAddress{
+		Required:     required,
+		PubKeys:      pubKeys,
+		RedeemScript: redeemScript,
+	})
+	wallets.SaveFile(nodeID)
+
+	fmt.Printf("New multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+}
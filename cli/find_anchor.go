@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	findAnchorCmd = &cobra.Command{
+		Use:   "find-anchor",
+		Short: "Finds the block that anchored data",
+		Long:  `find-anchor -data HEX|FILE - Prints the transaction and block that first anchored the data, or the sha256 of the file, see anchor.`,
+		Run:   findAnchor,
+	}
+)
+
+func findAnchor(cmd *cobra.Command, args []string) {
+	dataFlag, _ := cmd.Flags().GetString("data")
+	data := anchorData(dataFlag)
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	defer chain.Database.Close()
+
+	lookup, err := chain.LookupAnchor(data)
+	if err != nil {
+		fmt.Printf("%x: %s\n", data, err)
+		return
+	}
+
+	fmt.Printf("Data: %x\n", data)
+	fmt.Printf("Transaction: %s\n", lookup.Transaction.GetID())
+	fmt.Printf("Block hash: %x\n", lookup.Block.Hash)
+	fmt.Printf("Block height: %d\n", lookup.Block.Height)
+	fmt.Printf("Block time: %s\n", time.Unix(lookup.Block.Timestamp, 0).UTC())
+	fmt.Printf("Confirmations: %d\n", lookup.Confirmations)
+}
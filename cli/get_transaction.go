@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	getTransactionCmd = &cobra.Command{
+		Use:   "tx [id]",
+		Short: "Prints a confirmed transaction",
+		Long:  `Prints a confirmed transaction with its block and number of confirmations`,
+		Args:  cobra.ExactArgs(1),
+		Run:   getTransaction,
+	}
+)
+
+func getTransaction(cmd *cobra.Command, args []string) {
+	txID, err := hex.DecodeString(args[0])
+	if err != nil {
+		log.Panic("Transaction id not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	defer chain.Database.Close()
+
+	if !chain.TxIndexEnabled() {
+		fmt.Println("Transaction index is disabled, scanning the chain. Run `reindex --tx` to enable it.")
+	}
+
+	lookup, err := chain.LookupTransaction(txID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Block hash: %x\n", lookup.Block.Hash)
+	fmt.Printf("Block height: %d\n", lookup.Block.Height)
+	fmt.Printf("Position: %d\n", lookup.Position)
+	fmt.Printf("Confirmations: %d\n", lookup.Confirmations)
+	fmt.Println(lookup.Transaction)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcCmd = &cobra.Command{
+		Use:   "htlc",
+		Short: "Hash time locked contracts for atomic swaps",
+		Long: `Hash time locked contracts pay to a recipient who knows a secret, or back to the funder once they expire. An atomic swap between two chains:
+
+  1. A: htlc fund --to B --lock-blocks 20 on chain 1, prints the secret and its hash
+  2. B: htlc list on chain 1 to check the contract, then htlc fund --to A --secret-hash HASH --lock-blocks 10 on chain 2
+  3. A: htlc claim --secret SECRET on chain 2, which reveals the secret
+  4. B: htlc secret --contract CONTRACT on chain 2 with the contract B funded, then htlc claim --secret SECRET on chain 1
+
+If a side doesn't go through, htlc refund returns the funds after the lock.`,
+	}
+)
+
+// sendToNode submits tx to the node at the address given by the node flag.
+func sendToNode(cmd *cobra.Command, chain *blockchain.Blockchain, tx *blockchain.Transaction) {
+	node := nodeAddress(cmd, "node")
+
+	client := network.NewClient(chain.Logger, fmt.Sprintf("localhost:%s", nodeID))
+	client.SendTx(node, tx)
+
+	chain.Logger.Infow("htlc_tx_sent",
+		"tx_id", tx.GetID(),
+		"node_addr", node,
+		"size", tx.Size(),
+	)
+
+	fmt.Printf("Transaction %s sent to %s\n", tx.GetID(), node)
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"encoding/hex"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcClaimCmd = &cobra.Command{
+		Use:   "claim",
+		Short: "Claims hash time locked contracts with their secret",
+		Long:  `htlc claim -addr ADDR -secret SECRET [-to TO] [-fee FEE] - Sends every contract paying to ADDR whose secret is SECRET to TO, or to ADDR.`,
+		Run:   htlcClaim,
+	}
+)
+
+func htlcClaim(cmd *cobra.Command, args []string) {
+	address, _ := cmd.Flags().GetString("addr")
+	to, _ := cmd.Flags().GetString("to")
+	fee, _ := cmd.Flags().GetInt("fee")
+	secretHex, _ := cmd.Flags().GetString("secret")
+
+	if to == "" {
+		to = address
+	}
+
+	if !wallet.ValidateAddress(address) || !wallet.ValidateAddress(to) {
+		log.Panic("Address not valid")
+	}
+
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil || len(secret) != blockchain.HTLCSecretSize {
+		log.Panic("Secret not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.Load(nodeID)
+	if err != nil {
+		chain.Logger.Panicw("error_loading_wallets",
+			"error", err,
+		)
+	}
+	w := wallets.GetWallet(address)
+
+	tx := blockchain.NewHTLCClaimTransaction(&w, secret, to, fee, &UTXOSet)
+
+	sendToNode(cmd, chain, tx)
+}
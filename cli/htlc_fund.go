@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcFundCmd = &cobra.Command{
+		Use:   "fund",
+		Short: "Locks coins in a hash time locked contract",
+		Long:  `htlc fund -from FROM -to TO -amount AMOUNT (-lock-until LOCK | -lock-blocks BLOCKS) [-secret-hash HASH] - Pays amount to TO against the secret hashing to HASH, refundable to FROM once the height or unix time LOCK has passed, or BLOCKS blocks from now. Without a hash a new secret is generated and printed.`,
+		Run:   htlcFund,
+	}
+)
+
+func htlcFund(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	amount, _ := cmd.Flags().GetInt("amount")
+	fee, _ := cmd.Flags().GetInt("fee")
+	lockUntil, _ := cmd.Flags().GetUint32("lock-until")
+	lockBlocks, _ := cmd.Flags().GetUint32("lock-blocks")
+	secretHashHex, _ := cmd.Flags().GetString("secret-hash")
+
+	if (lockUntil > 0) == (lockBlocks > 0) {
+		log.Panic("Use either -lock-until or -lock-blocks")
+	}
+
+	fromVersion, refund, err := wallet.DecodeAddress(from)
+	if err != nil || fromVersion != wallet.PubKeyHashVersion() {
+		log.Panic("From address not valid")
+	}
+	toVersion, recipient, err := wallet.DecodeAddress(to)
+	if err != nil || toVersion != wallet.PubKeyHashVersion() {
+		log.Panic("To address not valid")
+	}
+
+	var secretHash []byte
+	if secretHashHex != "" {
+		secretHash, err = hex.DecodeString(secretHashHex)
+		if err != nil || len(secretHash) != sha256.Size {
+			log.Panic("Secret hash not valid")
+		}
+	} else {
+		secret := make([]byte, blockchain.HTLCSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			log.Panic(err)
+		}
+
+		hash := sha256.Sum256(secret)
+		secretHash = hash[:]
+
+		fmt.Printf("Secret: %x\n", secret)
+		fmt.Println("Keep it until the other side funded its contract, then claim it with htlc claim")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	if lockBlocks > 0 {
+		lockUntil = uint32(chain.GetBestHeight()) + lockBlocks
+	}
+
+	wallets, err := wallet.Load(nodeID)
+	if err != nil {
+		chain.Logger.Panicw("error_loading_wallets",
+			"error", err,
+		)
+	}
+	w := wallets.GetWallet(from)
+
+	contract := blockchain.HTLC{
+		SecretHash: secretHash,
+		Recipient:  recipient,
+		Refund:     refund,
+		LockTime:   lockUntil,
+	}
+	tx := blockchain.NewScriptTransaction(&w, blockchain.NewHTLCScript(contract), amount, fee, &UTXOSet)
+
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	fmt.Printf("Contract: %x:0, refundable after %d\n", tx.ID, lockUntil)
+
+	sendToNode(cmd, chain, tx)
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists the hash time locked contracts of an address",
+		Long:  `htlc list -addr ADDR - Prints the unspent contracts ADDR can claim or refund.`,
+		Run:   htlcList,
+	}
+)
+
+func htlcList(cmd *cobra.Command, args []string) {
+	address, _ := cmd.Flags().GetString("addr")
+
+	version, pubKeyHash, err := wallet.DecodeAddress(address)
+	if err != nil || version != wallet.PubKeyHashVersion() {
+		log.Panic("Address not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	fmt.Printf("Tip height: %d\n", chain.GetBestHeight())
+
+	for _, utxo := range UTXOSet.FindHTLCs(pubKeyHash) {
+		h, _ := blockchain.ExtractHTLC(utxo.Output.LockingScript())
+
+		role := "refund"
+		if bytes.Equal(h.Recipient, pubKeyHash) {
+			role = "claim"
+		}
+
+		fmt.Printf("%s value %d, %s, secret hash %x, refundable after %d, recipient %s, funder %s\n",
+			utxo.Outpoint, utxo.Output.Value, role, h.SecretHash, h.LockTime,
+			wallet.EncodeAddress(wallet.PubKeyHashVersion(), h.Recipient),
+			wallet.EncodeAddress(wallet.PubKeyHashVersion(), h.Refund),
+		)
+	}
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcRefundCmd = &cobra.Command{
+		Use:   "refund",
+		Short: "Refunds expired hash time locked contracts",
+		Long:  `htlc refund -addr ADDR [-to TO] [-fee FEE] - Sends every expired contract funded by ADDR back to TO, or to ADDR.`,
+		Run:   htlcRefund,
+	}
+)
+
+func htlcRefund(cmd *cobra.Command, args []string) {
+	address, _ := cmd.Flags().GetString("addr")
+	to, _ := cmd.Flags().GetString("to")
+	fee, _ := cmd.Flags().GetInt("fee")
+
+	if to == "" {
+		to = address
+	}
+
+	if !wallet.ValidateAddress(address) || !wallet.ValidateAddress(to) {
+		log.Panic("Address not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.Load(nodeID)
+	if err != nil {
+		chain.Logger.Panicw("error_loading_wallets",
+			"error", err,
+		)
+	}
+	w := wallets.GetWallet(address)
+
+	tx := blockchain.NewHTLCRefundTransaction(&w, to, fee, &UTXOSet)
+
+	sendToNode(cmd, chain, tx)
+}
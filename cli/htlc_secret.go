@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	htlcSecretCmd = &cobra.Command{
+		Use:   "secret",
+		Short: "Finds the secret revealed by a claimed contract",
+		Long:  `htlc secret --contract TXID:INDEX - Finds the claim of a contract you funded, as printed by htlc fund, and the secret it revealed, so the contract on the other chain of a swap can be claimed with it.`,
+		Run:   htlcSecret,
+	}
+)
+
+func htlcSecret(cmd *cobra.Command, args []string) {
+	contractFlag, _ := cmd.Flags().GetString("contract")
+
+	contract, err := blockchain.ParseOutpoint(contractFlag)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	defer chain.Database.Close()
+
+	secret, err := chain.FindHTLCSecret(contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Secret: %x\n", secret)
+}
@@ -20,8 +20,18 @@ func listAddresses(cmd *cobra.Command, args []string) {
 	wallets, err := wallet.Load(nodeID)
 	fmt.Println(err)
 	addresses := wallets.GetAllAddresses()
+	pubKeys, _ := cmd.Flags().GetBool("pubkeys")
 
 	for _, address := range addresses {
-		fmt.Println(address)
+		if pubKeys {
+			w := wallets.GetWallet(address)
+			fmt.Printf("%s %x\n", address, w.PublicKeyBytes())
+		} else {
+			fmt.Println(address)
+		}
+	}
+
+	for address, multisig := range wallets.Multisig {
+		fmt.Printf("%s %d of %d multisig\n", address, multisig.Required, len(multisig.PubKeys))
 	}
 }
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/spf13/cobra"
+)
+
+var (
+	multisigFinalizeCmd = &cobra.Command{
+		Use:   "multisig-finalize",
+		Short: "Finalizes and sends a multisig transaction",
+		Long:  `multisig-finalize -file FILE - Builds the unlocking scripts from the signatures collected in FILE and sends the transaction.`,
+		Run:   multisigFinalize,
+	}
+)
+
+func multisigFinalize(cmd *cobra.Command, args []string) {
+	file, _ := cmd.Flags().GetString("file")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	partial, err := blockchain.DeserializePartialTx(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := partial.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	logger, err := blockchain.SetupLogger(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := network.NewClient(logger, fmt.Sprintf("localhost:%s", nodeID))
+	client.SendTx(params.Active().DefaultNode(), tx)
+
+	logger.Infow("multisig_tx_sent_to_founding_node",
+		"tx_id", tx.GetID(),
+		"size", tx.Size(),
+	)
+
+	fmt.Printf("Transaction %s sent\n", tx.GetID())
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	multisigSignCmd = &cobra.Command{
+		Use:   "multisig-sign",
+		Short: "Adds signatures to a multisig transaction",
+		Long:  `multisig-sign -file FILE [-wallets NODE1,NODE2] - Signs the transaction in FILE with every multisig key found in the wallet files of the given nodes, by default the NODE_ID one.`,
+		Run:   multisigSign,
+	}
+)
+
+func multisigSign(cmd *cobra.Command, args []string) {
+	file, _ := cmd.Flags().GetString("file")
+	nodes, _ := cmd.Flags().GetStringSlice("wallets")
+
+	if len(nodes) == 0 {
+		nodes = []string{nodeID}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	partial, err := blockchain.DeserializePartialTx(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signed := 0
+	for _, node := range nodes {
+		wallets, err := wallet.Load(node)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		for address, w := range wallets.Wallets {
+			if !partial.HasKey(w.PublicKeyBytes()) {
+				continue
+			}
+
+			if err := partial.Sign(w.PrivateKey); err != nil {
+				log.Panic(err)
+			}
+
+			fmt.Printf("Signed with %s\n", address)
+			signed++
+		}
+	}
+
+	if signed == 0 {
+		log.Panic("No multisig key found in the wallet files")
+	}
+
+	if err := os.WriteFile(file, partial.Serialize(), 0644); err != nil {
+		log.Panic(err)
+	}
+
+	collected, required := partial.SignatureCount()
+	fmt.Printf("Transaction has %d of %d signatures\n", collected, required)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	multisigTxCmd = &cobra.Command{
+		Use:   "multisig-tx",
+		Short: "Creates an unsigned transaction spending multisig funds",
+		Long:  `multisig-tx -from MULTISIG -to TO -amount AMOUNT [-fee FEE] -out FILE - Writes a transaction spending from a multisig address to FILE for the key holders to sign with multisig-sign. The redeem script is taken from the wallet file unless given with -redeem-script.`,
+		Run:   multisigTx,
+	}
+)
+
+func multisigTx(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetString("to")
+	amount, _ := cmd.Flags().GetInt("amount")
+	fee, _ := cmd.Flags().GetInt("fee")
+	redeemHex, _ := cmd.Flags().GetString("redeem-script")
+	file, _ := cmd.Flags().GetString("out")
+
+	if !wallet.ValidateAddress(to) {
+		log.Panic("Address not valid")
+	}
+
+	var redeemScript []byte
+	if redeemHex != "" {
+		script, err := hex.DecodeString(redeemHex)
+		if err != nil {
+			log.Panic("Redeem script not valid")
+		}
+		redeemScript = script
+	} else {
+		wallets, _ := wallet.Load(nodeID)
+		multisig, ok := wallets.GetMultisig(from)
+		if !ok {
+			log.Panic("Multisig address not in wallet file, pass -redeem-script")
+		}
+		redeemScript = multisig.RedeemScript
+	}
+
+	multisig := wallet.MultisigAddress{RedeemScript: redeemScript}
+	if string(multisig.Address()) != from {
+		log.Panic("Redeem script doesnt match the multisig address")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	partial := blockchain.NewMultisigTransaction(redeemScript, to, amount, fee, &UTXOSet)
+
+	if err := os.WriteFile(file, partial.Serialize(), 0644); err != nil {
+		log.Panic(err)
+	}
+
+	_, required := partial.SignatureCount()
+	fmt.Printf("Unsigned transaction written to %s, it needs %d signatures\n", file, required)
+}
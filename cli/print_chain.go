@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
@@ -18,27 +19,76 @@ var (
 )
 
 func printChain(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetInt("from")
+	to, _ := cmd.Flags().GetInt("to")
+
 	chain := blockchain.ContinueBlockchain(nodeID)
 	defer chain.Database.Close()
-	iter := chain.Iterator()
 
-	for {
-		block := iter.Next()
+	if from >= 0 || to >= 0 {
+		if from < 0 {
+			from = 0
+		}
+		if to < 0 {
+			to = chain.GetBestHeight()
+		}
 
-		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		fmt.Printf("Height: %d\n", block.Height)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		blocks, err := chain.GetBlocksByHeightRange(from, to)
+		if err != nil {
+			log.Panic(err)
+		}
 
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+		for _, block := range blocks {
+			printBlock(chain, block)
 		}
 
-		fmt.Println()
+		return
+	}
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		printBlock(chain, block)
 
 		if len(block.PrevHash) == 0 {
 			break
 		}
 	}
 }
+
+func printBlock(chain *blockchain.Blockchain, block *blockchain.Block) {
+	fmt.Printf("Prev. hash: %x\n", block.PrevHash)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Version: %d\n", block.Version)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	fmt.Printf("Seal (%s): %s\n", chain.Consensus.Name(), strconv.FormatBool(checkSeal(chain, block)))
+	if len(block.Seal) > 0 {
+		fmt.Printf("Signature: %x\n", block.Seal)
+	}
+
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+
+	fmt.Println()
+}
+
+// checkSeal reports whether the consensus engine accepts the seal of block.
+func checkSeal(chain *blockchain.Blockchain, block *blockchain.Block) bool {
+	if len(block.PrevHash) == 0 {
+		return chain.Consensus.VerifySeal(chain, block, nil) == nil
+	}
+
+	parent, err := chain.GetBlock(block.PrevHash)
+	if err != nil {
+		return false
+	}
+
+	return chain.Consensus.VerifySeal(chain, block, &parent) == nil
+}
@@ -17,6 +17,13 @@ var (
 func reindexUTXO(cmd *cobra.Command, args []string) {
 	chain := blockchain.ContinueBlockchain(nodeID)
 	defer chain.Database.Close()
+
+	if reindexTxs, _ := cmd.Flags().GetBool("tx"); reindexTxs {
+		chain.Logger.Infow("tx_index_rebuilt",
+			"tx_count", chain.ReindexTransactions(),
+		)
+	}
+
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	UTXOSet.Reindex()
 
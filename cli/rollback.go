@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Disconnects blocks above a height",
+		Long:  `rollback --to-height N - Disconnects active chain blocks until the tip is at height N. --invalidate keeps fork choice from returning to them.`,
+		Run:   rollback,
+	}
+)
+
+func rollback(cmd *cobra.Command, args []string) {
+	height, _ := cmd.Flags().GetInt("to-height")
+	invalidate, _ := cmd.Flags().GetBool("invalidate")
+
+	if height < 0 {
+		log.Panic("Height not valid")
+	}
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	defer chain.Database.Close()
+
+	var lastDisconnected *blockchain.Block
+
+	for chain.GetBestHeight() > height {
+		block, err := chain.DisconnectTip()
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Disconnected block %x at height %d\n", block.Hash, block.Height)
+		lastDisconnected = block
+	}
+
+	if lastDisconnected == nil {
+		fmt.Printf("Tip is already at height %d\n", chain.GetBestHeight())
+		return
+	}
+
+	if invalidate {
+		chain.InvalidateBlock(lastDisconnected.Hash)
+	}
+
+	fmt.Printf("New tip: %x\n", chain.LastHash)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
 	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"github.com/aadejanovs/blockchain-demo/wallet"
 	"github.com/spf13/cobra"
 )
@@ -14,7 +15,7 @@ var (
 	sendCmd = &cobra.Command{
 		Use:   "send",
 		Short: "Send coins to address.",
-		Long:  `send -from FROM -to TO -amount AMOUNT -mine - Send amount of coins.`,
+		Long:  `send -from FROM -to TO -amount AMOUNT [-fee FEE | -fee-rate RATE] [-lock-until LOCK | -lock-blocks BLOCKS] -mine - Send amount of coins. The fee goes to the miner, either flat or per byte. Locked coins can only be claimed by the recipient once the height or unix time LOCK has passed, or BLOCKS blocks after the transaction was mined.`,
 		Run:   send,
 	}
 )
@@ -23,6 +24,17 @@ func send(cmd *cobra.Command, args []string) {
 	from, _ := cmd.Flags().GetString("from")
 	to, _ := cmd.Flags().GetString("to")
 	amount, _ := cmd.Flags().GetInt("amount")
+	fee, _ := cmd.Flags().GetInt("fee")
+	feeRate, _ := cmd.Flags().GetInt("fee-rate")
+	lockUntil, _ := cmd.Flags().GetUint32("lock-until")
+	lockBlocks, _ := cmd.Flags().GetUint16("lock-blocks")
+
+	if fee > 0 && feeRate > 0 {
+		log.Panic("Use either a flat fee or a fee rate")
+	}
+	if lockUntil > 0 && lockBlocks > 0 {
+		log.Panic("Use either an absolute or a relative lock")
+	}
 
 	if !wallet.ValidateAddress(to) {
 		log.Panic("Address not valid")
@@ -42,18 +54,42 @@ func send(cmd *cobra.Command, args []string) {
 			"error", err,
 		)
 	}
-	wallet := wallets.GetWallet(from)
+	w := wallets.GetWallet(from)
+
+	lockingScript, err := blockchain.AddressScript(to)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if lockUntil > 0 || lockBlocks > 0 {
+		version, pubKeyHash, _ := wallet.DecodeAddress(to)
+		if version != wallet.PubKeyHashVersion() {
+			log.Panic("Only pay to public key hash addresses can be locked")
+		}
 
-	tx := blockchain.NewTransaction(&wallet, to, amount, &UTXOSet)
+		lock := blockchain.TimeLock{Value: lockUntil}
+		if lockBlocks > 0 {
+			lock = blockchain.TimeLock{Relative: true, Value: blockchain.RelativeLockBlocks(lockBlocks)}
+		}
+		lockingScript = blockchain.NewTimeLockScript(lock, pubKeyHash)
+	}
+
+	var tx *blockchain.Transaction
+	if feeRate > 0 {
+		tx = blockchain.NewScriptTransactionWithFeeRate(&w, lockingScript, amount, feeRate, &UTXOSet)
+	} else {
+		tx = blockchain.NewScriptTransaction(&w, lockingScript, amount, fee, &UTXOSet)
+	}
 
 	chain.Logger.Infow("created_new_transaction",
 		"tx_id", tx.GetID(),
 		"from_addr", from,
 		"to_addr", to,
+		"size", tx.Size(),
 	)
 
 	client := network.NewClient(chain.Logger, fmt.Sprintf("localhost:%s", nodeID))
-	client.SendTx("localhost:3000", tx)
+	client.SendTx(params.Active().DefaultNode(), tx)
 
 	chain.Logger.Infow("new_tx_sent_to_founding_node",
 		"tx_id", tx.GetID(),
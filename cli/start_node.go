@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"github.com/aadejanovs/blockchain-demo/wallet"
 	"github.com/spf13/cobra"
 )
@@ -13,15 +14,16 @@ var (
 	startNodeCmd = &cobra.Command{
 		Use:   "start",
 		Short: "Start node",
-		Long:  `Start a node with ID specified in NODE_ID env. var. -miner enables mining`,
+		Long:  `Start a node with ID specified in NODE_ID env. var. -miner enables mining, -seed picks the first node to connect to. On proof of authority chains the node signs blocks with the wallet key of -signer, or of the miner address if it isn't set`,
 		Run:   startNode,
 	}
 )
 
 func startNode(cmd *cobra.Command, args []string) {
-	fmt.Printf("Starting node %s\n", nodeID)
+	fmt.Printf("Starting %s node %s\n", params.Active().Name, nodeID)
 
 	minerAddress, _ := cmd.Flags().GetString("miner")
+	seed := nodeAddress(cmd, "seed")
 
 	if len(minerAddress) > 0 {
 		if wallet.ValidateAddress(minerAddress) {
@@ -31,6 +33,31 @@ func startNode(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	server := network.NewServer(nodeID, minerAddress)
+	server := network.NewServer(nodeID, minerAddress, seed)
+
+	if workers, _ := cmd.Flags().GetInt("miner-workers"); workers > 0 {
+		server.MinerWorkers = workers
+	}
+
+	// Proof of authority chains sign blocks with the key of the signer
+	// address, which is the miner address unless --signer is given.
+	signer, _ := cmd.Flags().GetString("signer")
+	if len(signer) == 0 {
+		signer = minerAddress
+	}
+
+	if len(signer) > 0 {
+		wallets, err := wallet.Load(nodeID)
+		if w, ok := wallets.Wallets[signer]; err == nil && ok {
+			server.MinerKey = w.PrivateKey
+		} else if cmd.Flags().Changed("signer") {
+			log.Panic("Signer address not in wallet")
+		}
+	}
+
+	if txIndex, _ := cmd.Flags().GetBool("txindex"); txIndex {
+		server.EnableTxIndex()
+	}
+
 	server.Start()
 }
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/spf13/cobra"
+)
+
+var (
+	supplyCmd = &cobra.Command{
+		Use:   "supply",
+		Short: "Shows the issued coin supply",
+		Long:  `supply [--height N] - Shows the coins issued by the active chain up to a height next to what the monetary policy allows.`,
+		Run:   supply,
+	}
+)
+
+func supply(cmd *cobra.Command, args []string) {
+	height, _ := cmd.Flags().GetInt("height")
+
+	chain := blockchain.ContinueBlockchain(nodeID)
+	defer chain.Database.Close()
+
+	if height < 0 {
+		height = chain.GetBestHeight()
+	}
+
+	s, err := chain.IssuedSupply(height)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	policy := chain.Policy
+
+	fmt.Printf("Height: %d\n", s.Height)
+	fmt.Printf("Issued: %d\n", s.Issued)
+	fmt.Printf("Scheduled: %d\n", s.Scheduled)
+	fmt.Printf("Fees: %d\n", s.Fees)
+	fmt.Printf("Next subsidy: %d\n", policy.Subsidy(s.Height+1))
+	fmt.Printf("Policy: initial subsidy %d, halving interval %d, max supply %d\n",
+		policy.InitialSubsidy, policy.HalvingInterval, policy.MaxSupply)
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/network"
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyTxCmd = &cobra.Command{
+		Use:   "verify-tx [id]",
+		Short: "Confirms a transaction with a merkle proof",
+		Long:  `Requests a merkle proof for a transaction from a node and verifies it against the block header and the headers built on it, without a local copy of the chain. Proof of work headers must meet the minimum difficulty of the network; on proof of authority chains pass --validators, whose keys the headers must be signed by. Listens on the NODE_ID port for the answer.`,
+		Args:  cobra.ExactArgs(1),
+		Run:   verifyTx,
+	}
+)
+
+func verifyTx(cmd *cobra.Command, args []string) {
+	txID, err := hex.DecodeString(args[0])
+	if err != nil {
+		log.Panic("Transaction id not valid")
+	}
+
+	peer := nodeAddress(cmd, "peer")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	logger, err := blockchain.SetupLogger(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	client := network.NewClient(logger, fmt.Sprintf("localhost:%s", nodeID))
+
+	reply, err := client.FetchMerkleProof(peer, txID, timeout)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	rules := network.HeaderRules{MinDifficulty: params.Active().MinDifficulty}
+
+	validators, _ := cmd.Flags().GetStringSlice("validators")
+	for _, validator := range validators {
+		pubKey, err := hex.DecodeString(validator)
+		if err != nil {
+			log.Panicf("Validator key %s not valid", validator)
+		}
+		rules.Validators = append(rules.Validators, pubKey)
+	}
+
+	verified, err := network.VerifyMerkleProof(reply, rules)
+	if err != nil {
+		fmt.Printf("Transaction %x not verified: %s\n", txID, err)
+		return
+	}
+
+	fmt.Printf("Transaction %x verified\n", txID)
+	fmt.Printf("Block hash: %x\n", reply.BlockHash)
+	fmt.Printf("Block height: %d\n", verified.Header.Height)
+	fmt.Printf("Block time: %s\n", time.Unix(verified.Header.Timestamp, 0))
+	fmt.Printf("Confirmations: %d verified, %d reported by the peer\n", verified.Confirmations, reply.Confirmations)
+	if len(verified.Signer) > 0 {
+		fmt.Printf("Signed by validator: %x\n", verified.Signer)
+	}
+}
@@ -3,7 +3,6 @@ module github.com/aadejanovs/blockchain-demo
 go 1.21.5
 
 require (
-	github.com/cbergoon/merkletree v0.2.0
 	github.com/dgraph-io/badger v1.6.2
 	github.com/mr-tron/base58 v1.2.0
 	github.com/spf13/cobra v1.8.0
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+)
+
+// AddBlock passes block to the chain and brings the mempool and orphan pool
+// in line with the result. It reports whether the block was stored.
+func (s *Server) AddBlock(block *blockchain.Block, addrFrom string) bool {
+	update, err := s.chain.AddBlock(block)
+
+	if errors.Is(err, blockchain.ErrOrphanBlock) {
+		s.Orphans.Add(block)
+		if addrFrom != "" {
+			s.client.SendGetBlock(addrFrom, block.PrevHash)
+		}
+		return false
+	}
+
+	if err != nil {
+		reason, _ := blockchain.RejectReasonOf(err)
+
+		s.Logger.Warnw("block_rejected",
+			"hash", block.GetHash(),
+			"addr_from", addrFrom,
+			"reason", reason,
+			"error", err,
+		)
+		return false
+	}
+
+	s.applyChainUpdate(update)
+	s.processOrphans(block.Hash)
+
+	return true
+}
+
+// processOrphans connects orphans that were waiting for the block with hash.
+func (s *Server) processOrphans(hash []byte) {
+	queue := [][]byte{hash}
+
+	for len(queue) > 0 {
+		parentHash := queue[0]
+		queue = queue[1:]
+
+		for _, orphan := range s.Orphans.TakeChildren(parentHash) {
+			update, err := s.chain.AddBlock(orphan)
+			if err != nil {
+				s.Logger.Warnw("orphan_block_rejected",
+					"hash", orphan.GetHash(),
+					"error", err,
+				)
+				continue
+			}
+
+			s.applyChainUpdate(update)
+			queue = append(queue, orphan.Hash)
+		}
+	}
+}
+
+// applyChainUpdate moves transactions between the mempool and the chain
+// after the active chain changed: transactions from disconnected blocks go
+// back to the mempool, confirmed ones and the ones that conflict with them
+// are removed. Mining on the old tip is aborted.
+func (s *Server) applyChainUpdate(update *blockchain.ChainUpdate) {
+	if update.IsEmpty() {
+		return
+	}
+
+	s.abortMining()
+
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	for _, block := range update.Disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				s.Mempool.Add(tx)
+			}
+		}
+	}
+
+	spent := make(map[string]bool)
+
+	for _, block := range update.Connected {
+		for _, tx := range block.Transactions {
+			s.Mempool.Delete(tx.GetID())
+
+			for _, in := range tx.Inputs {
+				spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+			}
+		}
+	}
+
+	var evicted []string
+
+	s.Mempool.ForEach(func(tx *blockchain.Transaction) {
+		for _, in := range tx.Inputs {
+			if spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] {
+				evicted = append(evicted, tx.GetID())
+				return
+			}
+		}
+
+		if !s.chain.VerifyTransaction(tx) {
+			evicted = append(evicted, tx.GetID())
+		}
+	})
+
+	for _, txID := range evicted {
+		s.Mempool.Delete(txID)
+	}
+
+	if update.IsReorg() {
+		s.Logger.Warnw("mempool_updated_after_reorg",
+			"disconnected_blocks", len(update.Disconnected),
+			"connected_blocks", len(update.Connected),
+			"evicted_txs", len(evicted),
+			"mempool_len", s.Mempool.Len(),
+		)
+	}
+}
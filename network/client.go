@@ -6,8 +6,10 @@ import (
 	"io"
 	"log"
 	"net"
+	"time"
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"go.uber.org/zap"
 )
 
@@ -15,6 +17,7 @@ type ClientSettings struct {
 	Version       int
 	Protocol      string
 	CommandLength int
+	Magic         [4]byte
 }
 
 type Client struct {
@@ -31,13 +34,19 @@ func NewClient(logger *zap.SugaredLogger, nodeAddress string) *Client {
 			Version:       1,
 			Protocol:      "tcp",
 			CommandLength: 32,
+			Magic:         params.Active().Magic,
 		},
 	}
 }
 
 func (c *Client) SendVersion(addr string, chain *blockchain.Blockchain) {
 	bestHeight := chain.GetBestHeight()
-	payload := GobEncode(Version{Version: c.Version, BestHeight: bestHeight, AddrFrom: c.nodeAddress})
+	payload := GobEncode(Version{
+		Version:    c.Version,
+		BestHeight: bestHeight,
+		AddrFrom:   c.nodeAddress,
+		Timestamp:  time.Now().Unix(),
+	})
 	request := append(c.MsgNameToBytes(msgVersion), payload...)
 
 	c.Logger.Infow("sending_version_to_peer",
@@ -142,7 +151,12 @@ func (c *Client) SendGetMempoolTxs(addr string) {
 }
 
 func (c *Client) SendMempoolTxs(addr string, txs []blockchain.Transaction) {
-	payload := GobEncode(MempoolTxs{AddrFrom: c.nodeAddress, Txs: txs})
+	data := MempoolTxs{AddrFrom: c.nodeAddress}
+	for _, tx := range txs {
+		data.Txs = append(data.Txs, tx.Serialize())
+	}
+
+	payload := GobEncode(data)
 	request := append(c.MsgNameToBytes(msgMempoolTxs), payload...)
 
 	c.Logger.Infow("sending_mempool_txs_to_peer",
@@ -152,6 +166,32 @@ func (c *Client) SendMempoolTxs(addr string, txs []blockchain.Transaction) {
 	c.SendData(addr, request)
 }
 
+func (c *Client) SendGetMerkleProof(addr string, txID []byte) {
+	payload := GobEncode(GetMerkleProof{AddrFrom: c.nodeAddress, TxID: txID})
+	request := append(c.MsgNameToBytes(msgGetMerkleProof), payload...)
+
+	c.Logger.Infow("requesting_merkle_proof_from_peer",
+		"peer_addr", addr,
+		"tx_id", fmt.Sprintf("%x", txID),
+	)
+
+	c.SendData(addr, request)
+}
+
+func (c *Client) SendMerkleProof(addr string, proof MerkleProof) {
+	proof.AddrFrom = c.nodeAddress
+	payload := GobEncode(proof)
+	request := append(c.MsgNameToBytes(msgMerkleProof), payload...)
+
+	c.Logger.Infow("sending_merkle_proof_to_peer",
+		"peer_addr", addr,
+		"tx_id", fmt.Sprintf("%x", proof.TxID),
+		"found", proof.Found,
+	)
+
+	c.SendData(addr, request)
+}
+
 func (c *Client) SendData(addr string, data []byte) error {
 	conn, err := net.Dial(c.Protocol, addr)
 
@@ -177,6 +217,8 @@ func (c *Client) SendData(addr string, data []byte) error {
 	return nil
 }
 
+// MsgNameToBytes returns the start of a message: the network magic and the
+// message name padded to CommandLength.
 func (c *Client) MsgNameToBytes(cmd string) []byte {
 	bytes := make([]byte, c.CommandLength)
 
@@ -184,5 +226,5 @@ func (c *Client) MsgNameToBytes(cmd string) []byte {
 		bytes[i] = byte(c)
 	}
 
-	return bytes[:]
+	return append(c.Magic[:], bytes...)
 }
@@ -5,11 +5,14 @@ import (
 	"encoding/gob"
 	"fmt"
 	"log"
+	"net"
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
 )
 
-func (s *Server) HandleVersion(request []byte) {
+// HandleVersion answers a version message received from remoteAddr, the
+// address of the connection it arrived on.
+func (s *Server) HandleVersion(request []byte, remoteAddr net.Addr) {
 	payload := DecodeRequest[Version](request, s.MsgNameLength)
 
 	bestHeight := s.chain.GetBestHeight()
@@ -28,6 +31,25 @@ func (s *Server) HandleVersion(request []byte) {
 		s.PeersStorage.Add(payload.AddrFrom)
 	}
 
+	// Peers running older builds don't report their time. Samples are
+	// keyed by the host the connection came from rather than the address
+	// the peer claims, which a single peer could vary at will. Every
+	// message arrives on a new connection from a new port, so the port
+	// isn't part of the key.
+	if payload.Timestamp != 0 {
+		host := remoteAddr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		s.chain.TimeSource.AddTimeSample(host, payload.Timestamp)
+		s.Logger.Debugw("network_time_adjusted",
+			"peer_addr", payload.AddrFrom,
+			"remote_host", host,
+			"offset", s.chain.TimeSource.Offset(),
+		)
+	}
+
 	if bestHeight < otherHeight {
 		s.client.GetNextBlock(payload.AddrFrom, bestHeight)
 	} else if bestHeight > otherHeight {
@@ -60,7 +82,7 @@ func (s *Server) HandleBlockCreated(request []byte) {
 		"block_hash", payload.BlockHash,
 	)
 
-	if !s.chain.BlockExists(payload.BlockHash) {
+	if !s.chain.BlockExists(payload.BlockHash) && !s.Orphans.Exists(payload.BlockHash) {
 		s.client.SendGetBlock(payload.AddrFrom, payload.BlockHash)
 	}
 }
@@ -100,8 +122,18 @@ func (s *Server) HandleBlockByHeightRequested(request []byte) {
 func (s *Server) HandleBlock(request []byte) {
 	payload := DecodeRequest[Block](request, s.MsgNameLength)
 
-	blockData := payload.Block
-	block := blockchain.Deserialize(blockData)
+	// Unlike blocks read from the database, blocks from peers have to be
+	// in the canonical encoding; the gob stored by older builds isn't
+	// accepted.
+	block, err := blockchain.DecodeBlock(payload.Block)
+	if err != nil {
+		s.Logger.Warnw("malformed_block_dropped",
+			"addr_from", payload.AddrFrom,
+			"error", err,
+		)
+		return
+	}
+	block.SortTxs()
 
 	s.Logger.Infow("received_block_message",
 		"addr_from", payload.AddrFrom,
@@ -111,25 +143,23 @@ func (s *Server) HandleBlock(request []byte) {
 	)
 
 	if !s.chain.BlockExists(block.Hash) {
-		err := s.chain.AddBlock(block)
-		if err == nil {
-			for _, txID := range block.TxIds() {
-				UTXOSet := blockchain.UTXOSet{Blockchain: s.chain}
-				UTXOSet.Reindex()
-
-				s.Mempool.Delete(txID)
-			}
+		if s.AddBlock(block, payload.AddrFrom) {
+			s.client.SendVersion(payload.AddrFrom, s.chain)
 		}
-
-		s.client.SendVersion(s.NodeAddress, s.chain)
 	}
 }
 
 func (s *Server) HandleTx(request []byte) {
 	payload := DecodeRequest[Tx](request, s.MsgNameLength)
 
-	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
+	tx, err := blockchain.DecodeTransaction(payload.Transaction)
+	if err != nil {
+		s.Logger.Warnw("malformed_tx_dropped",
+			"addr_from", payload.AddrFrom,
+			"error", err,
+		)
+		return
+	}
 
 	s.Logger.Infow("received_tx_message",
 		"addr_from", payload.AddrFrom,
@@ -140,11 +170,23 @@ func (s *Server) HandleTx(request []byte) {
 	_, txInMempool := s.Mempool.Get(tx.GetID())
 
 	if !txInMempool {
-		s.Mempool.Add(&tx)
+		if err := s.chain.CheckTransaction(tx); err != nil {
+			reason, _ := blockchain.RejectReasonOf(err)
+
+			s.Logger.Warnw("tx_rejected",
+				"tx_id", tx.GetID(),
+				"addr_from", payload.AddrFrom,
+				"reason", reason,
+				"error", err,
+			)
+			return
+		}
+
+		s.Mempool.Add(tx)
 
 		s.PeersStorage.ForEach(func(peerAddr string) {
 			if peerAddr != payload.AddrFrom {
-				s.client.SendTx(peerAddr, &tx)
+				s.client.SendTx(peerAddr, tx)
 			}
 		})
 	}
@@ -13,4 +13,7 @@ const (
 
 	msgGetMempoolTxs string = "get_mempool_txs"
 	msgMempoolTxs    string = "mempool_txs"
+
+	msgGetMerkleProof string = "get_merkle_proof"
+	msgMerkleProof    string = "merkle_proof"
 )
@@ -1,54 +1,156 @@
 package network
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
 	"github.com/aadejanovs/blockchain-demo/blockchain"
 )
 
-func (s *Server) MineTx() {
-	var txs []*blockchain.Transaction
-
+// MineTx mines blocks with the mempool transactions paying the most fee
+// per byte until the mempool is empty. When another block becomes the tip
+// meanwhile the block would be stale, so mining starts over with a new
+// template.
+func (s *Server) MineTx(ctx context.Context) {
 	if s.Mempool.Len() <= 0 {
 		s.Logger.Infow("mempool_empty")
 		return
 	}
 
+	for s.Mempool.Len() > 0 && ctx.Err() == nil {
+		if !s.mineBlock(ctx) {
+			return
+		}
+	}
+}
+
+// mineBlock mines one block and announces it to the peers. It reports
+// whether mining should go on, which is the case once the block was added
+// or when a new tip aborted mining.
+func (s *Server) mineBlock(ctx context.Context) bool {
+	var txs []*blockchain.Transaction
+
+	// Registered before the template is built, so that a tip change while
+	// building it aborts mining too.
+	mineCtx, cancel := context.WithCancel(ctx)
+	s.setCancelMining(cancel)
+	defer cancel()
+
 	s.Logger.Infow("mining_block",
 		"mempool_len", s.Mempool.Len(),
 		"block_time", s.BlockTime,
 	)
 
-	for id := range s.Mempool.pool {
-		tx, _ := s.Mempool.Get(id)
-		if s.chain.VerifyTransaction(tx) {
-			txs = append(txs, tx)
+	type candidate struct {
+		tx  *blockchain.Transaction
+		fee int
+	}
+
+	var candidates []candidate
+
+	s.Mempool.ForEach(func(tx *blockchain.Transaction) {
+		fee, err := s.chain.TransactionFee(tx)
+		if err != nil {
+			s.Logger.Warnw("skipping_invalid_mempool_tx",
+				"tx_id", tx.GetID(),
+				"error", err,
+			)
+			return
 		}
+
+		candidates = append(candidates, candidate{tx: tx, fee: fee})
+	})
+
+	// Transactions paying the most per byte go first.
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].fee*candidates[j].tx.Size() > candidates[j].fee*candidates[i].tx.Size()
+	})
+
+	spent := make(map[string]bool)
+	fees := 0
+
+Txs:
+	for _, c := range candidates {
+		// Two mempool transactions can spend the same output; only the
+		// first one makes it into the block.
+		for _, in := range c.tx.Inputs {
+			if spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] {
+				continue Txs
+			}
+		}
+		for _, in := range c.tx.Inputs {
+			spent[fmt.Sprintf("%x:%d", in.ID, in.Out)] = true
+		}
+
+		txs = append(txs, c.tx)
+		fees += c.fee
 	}
 
 	if len(txs) == 0 {
 		s.Logger.Errorw("all_transactions_invalid")
-		return
+		return false
 	}
 
-	cbTx := blockchain.CoinbaseTx(s.MinerAddress, "")
+	subsidy := s.chain.Policy.Subsidy(s.chain.GetBestHeight() + 1)
+	cbTx := blockchain.CoinbaseTx(s.MinerAddress, "", subsidy+fees)
 	txs = append(txs, cbTx)
 
-	newBlock := s.chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{Blockchain: s.chain}
-	UTXOSet.Reindex()
-
-	s.Logger.Infow("new_block_mined",
-		"hash", newBlock.GetHash(),
+	s.Logger.Infow("block_template_created",
+		"tx_count", len(txs),
+		"subsidy", subsidy,
+		"fees", fees,
 	)
 
-	for _, tx := range txs {
-		s.Mempool.Delete(tx.GetID())
+	newBlock, err := s.chain.MineBlock(mineCtx, txs)
+	s.setCancelMining(nil)
+
+	if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		s.Logger.Infow("mining_restarted_on_new_tip")
+		return true
+	}
+	// The tip changed after the candidates were checked; the next
+	// template leaves out the transactions that became invalid.
+	if errors.Is(err, blockchain.ErrInvalidTransaction) {
+		s.Logger.Warnw("mining_restarted_on_invalid_tx",
+			"error", err,
+		)
+		return true
+	}
+	if err != nil {
+		s.Logger.Warnw("mining_failed",
+			"error", err,
+		)
+		return false
+	}
+
+	if !s.AddBlock(newBlock, "") {
+		return false
 	}
 
 	s.PeersStorage.ForEach(func(peerAddr string) {
 		s.client.SendBlockCreated(peerAddr, newBlock)
 	})
 
-	if s.Mempool.Len() > 0 {
-		s.MineTx()
+	return true
+}
+
+func (s *Server) setCancelMining(cancel context.CancelFunc) {
+	s.miningLock.Lock()
+	defer s.miningLock.Unlock()
+
+	s.cancelMining = cancel
+}
+
+// abortMining stops mining the current block, whose parent is no longer
+// the tip.
+func (s *Server) abortMining() {
+	s.miningLock.Lock()
+	defer s.miningLock.Unlock()
+
+	if s.cancelMining != nil {
+		s.cancelMining()
+		s.cancelMining = nil
 	}
 }
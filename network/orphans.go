@@ -0,0 +1,79 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"go.uber.org/zap"
+)
+
+const maxOrphanBlocks = 100
+
+// OrphanPool keeps blocks whose parent hasn't been received yet, so they can
+// be connected once the missing part of their branch arrives.
+type OrphanPool struct {
+	Logger *zap.SugaredLogger
+
+	poolLock sync.Mutex
+	blocks   map[string]*blockchain.Block
+	byPrev   map[string][]*blockchain.Block
+}
+
+func NewOrphanPool(logger *zap.SugaredLogger) *OrphanPool {
+	return &OrphanPool{
+		Logger: logger,
+		blocks: make(map[string]*blockchain.Block),
+		byPrev: make(map[string][]*blockchain.Block),
+	}
+}
+
+func (op *OrphanPool) Add(block *blockchain.Block) {
+	op.poolLock.Lock()
+	defer op.poolLock.Unlock()
+
+	if _, ok := op.blocks[block.GetHash()]; ok {
+		return
+	}
+
+	if len(op.blocks) >= maxOrphanBlocks {
+		op.Logger.Warnw("orphan_pool_full",
+			"dropped_block_hash", block.GetHash(),
+		)
+		return
+	}
+
+	prevHash := fmt.Sprintf("%x", block.PrevHash)
+	op.blocks[block.GetHash()] = block
+	op.byPrev[prevHash] = append(op.byPrev[prevHash], block)
+
+	op.Logger.Infow("orphan_block_added",
+		"hash", block.GetHash(),
+		"prev_hash", prevHash,
+		"pool_len", len(op.blocks),
+	)
+}
+
+func (op *OrphanPool) Exists(hash []byte) bool {
+	op.poolLock.Lock()
+	defer op.poolLock.Unlock()
+
+	_, ok := op.blocks[fmt.Sprintf("%x", hash)]
+	return ok
+}
+
+// TakeChildren removes and returns orphans whose parent is prevHash.
+func (op *OrphanPool) TakeChildren(prevHash []byte) []*blockchain.Block {
+	op.poolLock.Lock()
+	defer op.poolLock.Unlock()
+
+	key := fmt.Sprintf("%x", prevHash)
+	children := op.byPrev[key]
+	delete(op.byPrev, key)
+
+	for _, child := range children {
+		delete(op.blocks, child.GetHash())
+	}
+
+	return children
+}
@@ -1,15 +1,19 @@
 package network
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/aadejanovs/blockchain-demo/blockchain"
+	"github.com/aadejanovs/blockchain-demo/params"
 	"go.uber.org/zap"
 
 	SYS "syscall"
@@ -21,10 +25,13 @@ type ServerSettings struct {
 	Protocol      string
 	Version       int
 	MsgNameLength int
+	Magic         [4]byte
 	NodeID        string
 	NodeAddress   string
 	IsMiner       bool
 	MinerAddress  string
+	MinerWorkers  int
+	MinerKey      ed25519.PrivateKey
 	BlockTime     time.Duration
 }
 
@@ -36,20 +43,28 @@ type Server struct {
 	client       *Client
 	PeersStorage *PeersStorage
 	Mempool      *Mempool
+	Orphans      *OrphanPool
+
+	updateLock sync.Mutex
+
+	miningLock   sync.Mutex
+	cancelMining context.CancelFunc
 }
 
-func NewServer(nodeID, minerAddress string) *Server {
+// NewServer creates the node listening on the NODE_ID port. It connects to
+// seedAddr first, unless that is its own address, which makes it the first
+// node of a network.
+func NewServer(nodeID, minerAddress, seedAddr string) *Server {
 	logger, err := blockchain.SetupLogger(nodeID)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	serverAddr := fmt.Sprintf("localhost:%s", nodeID)
-	foundingNodeAddr := "localhost:3000"
 
 	knownPeers := []string{}
-	if serverAddr != foundingNodeAddr {
-		knownPeers = append(knownPeers, foundingNodeAddr)
+	if serverAddr != seedAddr {
+		knownPeers = append(knownPeers, seedAddr)
 	}
 
 	server := &Server{
@@ -59,13 +74,16 @@ func NewServer(nodeID, minerAddress string) *Server {
 		PeersStorage: NewPeersStorage(logger, serverAddr, knownPeers),
 
 		Mempool: NewMemPool(logger),
+		Orphans: NewOrphanPool(logger),
 		ServerSettings: ServerSettings{
 			NodeID:        nodeID,
 			NodeAddress:   serverAddr,
 			Protocol:      "tcp",
 			Version:       1,
 			MsgNameLength: 32,
-			BlockTime:     time.Second * 5,
+			Magic:         params.Active().Magic,
+			MinerWorkers:  runtime.NumCPU(),
+			BlockTime:     params.Active().TargetBlockTime,
 		},
 	}
 
@@ -83,6 +101,17 @@ func NewServer(nodeID, minerAddress string) *Server {
 	return server
 }
 
+// EnableTxIndex builds the transaction index if the node doesn't keep one yet.
+func (s *Server) EnableTxIndex() {
+	if s.chain.TxIndexEnabled() {
+		return
+	}
+
+	s.Logger.Infow("tx_index_built",
+		"tx_count", s.chain.ReindexTransactions(),
+	)
+}
+
 func (s *Server) Start() {
 	ln, err := net.Listen(s.Protocol, s.NodeAddress)
 	if err != nil {
@@ -102,7 +131,8 @@ func (s *Server) Start() {
 	}
 
 	if s.IsMiner {
-		go s.StartMining()
+		s.configureSealing()
+		go s.StartMining(context.Background())
 	}
 
 	for {
@@ -115,16 +145,39 @@ func (s *Server) Start() {
 	}
 }
 
-func (s *Server) StartMining() {
+// configureSealing passes the mining settings to the consensus engine: the
+// goroutines searching for proofs of work, or the key signing proof of
+// authority blocks.
+func (s *Server) configureSealing() {
+	switch engine := s.chain.Consensus.(type) {
+	case *blockchain.ProofOfWorkConsensus:
+		engine.Workers = s.MinerWorkers
+	case *blockchain.ProofOfAuthority:
+		engine.Signer = s.MinerKey
+	}
+
+	s.Logger.Infow("consensus_engine",
+		"engine", s.chain.Consensus.Name(),
+	)
+}
+
+// StartMining mines the mempool every block time until ctx is done.
+func (s *Server) StartMining(ctx context.Context) {
 	ticker := time.NewTicker(s.BlockTime)
+	defer ticker.Stop()
 
 	s.Logger.Infow("mining_process_started",
 		"block_time", s.BlockTime,
+		"workers", s.MinerWorkers,
 	)
 
 	for {
-		<-ticker.C
-		s.MineTx()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.MineTx(ctx)
+		}
 	}
 }
 
@@ -136,11 +189,19 @@ func (s *Server) HandleConnection(conn net.Conn) {
 		log.Panic(err)
 	}
 
+	req, ok := stripMagic(s.Magic, req)
+	if !ok || len(req) < s.MsgNameLength {
+		s.Logger.Warnw("foreign_message_dropped",
+			"remote_addr", conn.RemoteAddr().String(),
+		)
+		return
+	}
+
 	msgName := BytesToMsg(req[:s.MsgNameLength])
 
 	switch msgName {
 	case msgVersion:
-		s.HandleVersion(req)
+		s.HandleVersion(req, conn.RemoteAddr())
 	case msgAddresses:
 		s.HandleAddresses(req)
 
@@ -158,6 +219,9 @@ func (s *Server) HandleConnection(conn net.Conn) {
 
 	case msgGetMempoolTxs:
 		s.HandleGetMempoolTxs(req)
+
+	case msgGetMerkleProof:
+		s.HandleGetMerkleProof(req)
 	default:
 		s.Logger.Errorf("unkown_message_received",
 			"name", msgName,
@@ -0,0 +1,233 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/aadejanovs/blockchain-demo/blockchain"
+)
+
+// maxProofHeaders bounds the headers of confirming blocks a merkle proof
+// carries.
+const maxProofHeaders = 100
+
+func (s *Server) HandleGetMerkleProof(request []byte) {
+	payload := DecodeRequest[GetMerkleProof](request, s.MsgNameLength)
+
+	s.Logger.Infow("received_get_merkle_proof_query",
+		"addr_from", payload.AddrFrom,
+		"tx_id", fmt.Sprintf("%x", payload.TxID),
+	)
+
+	reply := MerkleProof{TxID: payload.TxID}
+
+	lookup, err := s.chain.LookupTransaction(payload.TxID)
+	if err == nil {
+		var proof blockchain.MerkleProof
+
+		proof, err = lookup.Block.MerkleProof(payload.TxID)
+		if err == nil {
+			reply.Found = true
+			reply.BlockHash = lookup.Block.Hash
+			reply.Header = lookup.Block.Header().Serialize()
+			reply.Seal = lookup.Block.Seal
+			reply.Confirmations = lookup.Confirmations
+			reply.Index = proof.Index
+			reply.Hashes = proof.Hashes
+			reply.Headers, reply.Seals, err = s.confirmingHeaders(lookup.Block)
+		}
+	}
+
+	if err != nil {
+		reply.Error = err.Error()
+
+		s.Logger.Infow("merkle_proof_unavailable",
+			"tx_id", fmt.Sprintf("%x", payload.TxID),
+			"error", err,
+		)
+	}
+
+	s.client.SendMerkleProof(payload.AddrFrom, reply)
+}
+
+// confirmingHeaders returns the headers and seals of the active chain
+// blocks built on block, up to maxProofHeaders of them.
+func (s *Server) confirmingHeaders(block *blockchain.Block) ([][]byte, [][]byte, error) {
+	var headers, seals [][]byte
+
+	tip := s.chain.GetBestHeight()
+
+	for height := block.Height + 1; height <= tip && len(headers) < maxProofHeaders; height++ {
+		b, err := s.chain.GetBlockByHeight(height)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		headers = append(headers, b.Header().Serialize())
+		seals = append(seals, b.Seal)
+	}
+
+	return headers, seals, nil
+}
+
+// FetchMerkleProof asks peer for the merkle proof of a transaction and
+// waits for the answer on the client address. It is used by light clients,
+// which don't run a server.
+func (c *Client) FetchMerkleProof(peer string, txID []byte, timeout time.Duration) (*MerkleProof, error) {
+	ln, err := net.Listen(c.Protocol, c.nodeAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	c.SendGetMerkleProof(peer, txID)
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ln.(*net.TCPListener).SetDeadline(deadline)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("no merkle proof from %s: %w", peer, err)
+		}
+
+		req, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			continue
+		}
+
+		req, ok := stripMagic(c.Magic, req)
+		if !ok || len(req) < c.CommandLength {
+			continue
+		}
+
+		if BytesToMsg(req[:c.CommandLength]) != msgMerkleProof {
+			continue
+		}
+
+		reply := DecodeRequest[MerkleProof](req, c.CommandLength)
+		if !bytes.Equal(reply.TxID, txID) {
+			continue
+		}
+
+		return &reply, nil
+	}
+}
+
+// HeaderRules is what a light client requires from the headers of a
+// merkle proof, since it has no chain to check them against. Proof of work
+// headers must have a target no easier than MinDifficulty; if Validators
+// is set, headers must be signed by one of them instead.
+type HeaderRules struct {
+	MinDifficulty int
+	Validators    [][]byte
+}
+
+// VerifiedProof is a merkle proof checked by VerifyMerkleProof.
+// Confirmations counts the block and the checked headers built on it, so
+// it can be lower than the confirmations the peer reported.
+type VerifiedProof struct {
+	Header        blockchain.BlockHeader
+	Signer        []byte
+	Confirmations int
+}
+
+// VerifyMerkleProof checks a MerkleProof message without the chain: the
+// header and the headers built on it have to link up and follow rules,
+// and the proof has to connect the transaction to the header's merkle
+// root.
+func VerifyMerkleProof(reply *MerkleProof, rules HeaderRules) (*VerifiedProof, error) {
+	if !reply.Found {
+		return nil, fmt.Errorf("no proof: %s", reply.Error)
+	}
+
+	header, err := blockchain.DeserializeBlockHeader(reply.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header.Hash(), reply.BlockHash) {
+		return nil, errors.New("block header doesnt match block hash")
+	}
+
+	signer, err := rules.checkHeader(header, reply.Seal)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := blockchain.MerkleProof{Index: reply.Index, Hashes: reply.Hashes}
+	if !blockchain.VerifyMerkleProof(header.MerkleRoot, reply.TxID, proof) {
+		return nil, errors.New("merkle proof doesnt match block header")
+	}
+
+	if len(reply.Headers) != len(reply.Seals) || len(reply.Headers) > maxProofHeaders {
+		return nil, errors.New("confirming headers dont match their seals")
+	}
+
+	prev := header
+	for i, data := range reply.Headers {
+		next, err := blockchain.DeserializeBlockHeader(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(next.PrevHash, prev.Hash()) || next.Height != prev.Height+1 {
+			return nil, fmt.Errorf("confirming header %d doesnt follow the block before it", i)
+		}
+
+		if _, err := rules.checkHeader(next, reply.Seals[i]); err != nil {
+			return nil, fmt.Errorf("confirming header %d: %w", i, err)
+		}
+
+		prev = next
+	}
+
+	return &VerifiedProof{
+		Header:        header,
+		Signer:        signer,
+		Confirmations: len(reply.Headers) + 1,
+	}, nil
+}
+
+// checkHeader checks the proof of work or the seal of a header and
+// returns the validator that signed it, if any.
+func (r HeaderRules) checkHeader(header blockchain.BlockHeader, seal []byte) ([]byte, error) {
+	if len(r.Validators) == 0 {
+		if len(seal) > 0 {
+			return nil, errors.New("block is signed, but no validators are known")
+		}
+
+		if blockchain.CompactToBig(header.Bits).Cmp(blockchain.CompactToBig(blockchain.DifficultyToBits(r.MinDifficulty))) > 0 {
+			return nil, fmt.Errorf("block target %08x is easier than the network minimum", header.Bits)
+		}
+
+		if !header.CheckProofOfWork() {
+			return nil, errors.New("block header doesnt meet its target")
+		}
+
+		return nil, nil
+	}
+
+	signer, err := blockchain.AuthoritySigner(header.Hash(), seal)
+	if err != nil {
+		return nil, fmt.Errorf("block seal invalid: %w", err)
+	}
+
+	if !bytes.Equal(signer, header.Signer) {
+		return nil, errors.New("block seal isnt from the signer in the header")
+	}
+
+	for _, validator := range r.Validators {
+		if bytes.Equal(validator, signer) {
+			return signer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("block signer %x is not a validator", signer)
+}
@@ -1,7 +1,5 @@
 package network
 
-import "github.com/aadejanovs/blockchain-demo/blockchain"
-
 type (
 	Addr struct {
 		AddrList []string
@@ -53,7 +51,7 @@ type (
 
 	MempoolTxs struct {
 		AddrFrom string
-		Txs      []blockchain.Transaction
+		Txs      [][]byte
 	}
 
 	Inv struct {
@@ -67,9 +65,35 @@ type (
 		Transaction []byte
 	}
 
+	GetMerkleProof struct {
+		AddrFrom string
+		TxID     []byte
+	}
+
+	// MerkleProof answers GetMerkleProof. Header is the serialized block
+	// header, which with the seal of proof of authority blocks is all a
+	// light client needs to check the proof. Headers and Seals belong to
+	// the blocks built on it, up to maxProofHeaders of them, so that the
+	// client can check the confirmations as well.
+	MerkleProof struct {
+		AddrFrom      string
+		TxID          []byte
+		Found         bool
+		Error         string
+		BlockHash     []byte
+		Header        []byte
+		Seal          []byte
+		Confirmations int
+		Index         int
+		Hashes        [][]byte
+		Headers       [][]byte
+		Seals         [][]byte
+	}
+
 	Version struct {
 		Version    int
 		BestHeight int
 		AddrFrom   string
+		Timestamp  int64
 	}
 )
@@ -28,3 +28,13 @@ func BytesToMsg(bytes []byte) string {
 
 	return string(cmd)
 }
+
+// stripMagic removes the network magic from the start of a message. It
+// reports false for messages of other networks.
+func stripMagic(magic [4]byte, req []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(req, magic[:]) {
+		return nil, false
+	}
+
+	return req[len(magic):], true
+}
@@ -0,0 +1,205 @@
+package params
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Params defines a network: how its nodes recognize each other, what its
+// addresses look like, where its data is kept and the consensus rules of
+// the chains created on it.
+type Params struct {
+	Name string
+
+	// Magic starts every message, so nodes of different networks can't
+	// talk to each other.
+	Magic [4]byte
+
+	// PubKeyHashVersion and ScriptHashVersion prefix the addresses paying
+	// to the hash of a public key and of a script.
+	PubKeyHashVersion byte
+	ScriptHashVersion byte
+
+	// DefaultPort is the node port used when NODE_ID isn't set, and the
+	// port of the node connected to first.
+	DefaultPort string
+
+	// DataDir holds the chain databases and wallet files of the network.
+	// The directories of the networks are siblings, so removing one
+	// doesn't remove the others.
+	DataDir string
+
+	// GenesisData is the message in the coinbase of the genesis block.
+	GenesisData string
+
+	// InitialDifficulty is the number of leading zero bits required from
+	// the genesis block and the blocks of the first retarget window, and
+	// MinDifficulty bounds the easiest target the chain may retarget to.
+	InitialDifficulty int
+	MinDifficulty     int
+	// RetargetInterval is the number of blocks between difficulty changes.
+	// Zero keeps the initial difficulty forever.
+	RetargetInterval int
+	// TargetBlockTime is the block interval retargeting converges on and
+	// how often miners build a block.
+	TargetBlockTime time.Duration
+
+	// Monetary policy of new chains, unless chosen when creating them.
+	InitialSubsidy   int
+	HalvingInterval  int
+	MaxSupply        int
+	CoinbaseMaturity int
+}
+
+// legacyDataDir is where older builds kept the chains and wallets that
+// belong to MainNet now.
+const legacyDataDir = "./tmp"
+
+var (
+	// MainNet is the network nodes join by default, and the one chains
+	// and wallets created before networks existed belong to. It keeps the
+	// address versions of older builds, so the addresses they handed out
+	// stay valid.
+	MainNet = Params{
+		Name:              "mainnet",
+		Magic:             [4]byte{0xbd, 0xc4, 0x1e, 0x01},
+		PubKeyHashVersion: 0x00,
+		ScriptHashVersion: 0x05,
+		DefaultPort:       "3000",
+		DataDir:           "./tmp/mainnet",
+		GenesisData:       "Genesis data",
+		InitialDifficulty: 18,
+		MinDifficulty:     8,
+		RetargetInterval:  10,
+		TargetBlockTime:   5 * time.Second,
+		InitialSubsidy:    20,
+		HalvingInterval:   210,
+		MaxSupply:         0,
+		CoinbaseMaturity:  3,
+	}
+
+	// TestNet follows the rules of MainNet with an easier difficulty.
+	TestNet = Params{
+		Name:              "testnet",
+		Magic:             [4]byte{0xbd, 0xc4, 0x1e, 0x02},
+		PubKeyHashVersion: 0x41,
+		ScriptHashVersion: 0x5d,
+		DefaultPort:       "13000",
+		DataDir:           "./tmp/testnet",
+		GenesisData:       "Testnet genesis data",
+		InitialDifficulty: 16,
+		MinDifficulty:     8,
+		RetargetInterval:  10,
+		TargetBlockTime:   5 * time.Second,
+		InitialSubsidy:    20,
+		HalvingInterval:   210,
+		MaxSupply:         0,
+		CoinbaseMaturity:  3,
+	}
+
+	// RegTest is meant for local experiments and tests: blocks are mined
+	// almost instantly and rewards can be spent right away.
+	RegTest = Params{
+		Name:              "regtest",
+		Magic:             [4]byte{0xbd, 0xc4, 0x1e, 0x03},
+		PubKeyHashVersion: 0x7b,
+		ScriptHashVersion: 0x8e,
+		DefaultPort:       "23000",
+		DataDir:           "./tmp/regtest",
+		GenesisData:       "Regtest genesis data",
+		InitialDifficulty: 1,
+		MinDifficulty:     1,
+		RetargetInterval:  0,
+		TargetBlockTime:   time.Second,
+		InitialSubsidy:    50,
+		HalvingInterval:   150,
+		MaxSupply:         0,
+		CoinbaseMaturity:  0,
+	}
+
+	networks = []*Params{&MainNet, &TestNet, &RegTest}
+
+	active = &MainNet
+)
+
+// Names lists the networks that can be selected.
+func Names() []string {
+	var names []string
+	for _, p := range networks {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+// ByName returns the network called name.
+func ByName(name string) (*Params, error) {
+	for _, p := range networks {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network %q, expected one of %s", name, strings.Join(Names(), ", "))
+}
+
+// Select makes the network called name the one Active returns.
+func Select(name string) error {
+	p, err := ByName(name)
+	if err != nil {
+		return err
+	}
+
+	active = p
+
+	return nil
+}
+
+// Active returns the selected network, MainNet unless Select was called.
+func Active() *Params {
+	return active
+}
+
+// DataPath returns the path of a file or directory in the data directory.
+func (p *Params) DataPath(name string) string {
+	return filepath.Join(p.DataDir, name)
+}
+
+// MigrateLegacyData moves name, a chain database or wallet file older
+// builds kept directly in legacyDataDir, into the data directory of MainNet.
+// Other networks have no legacy data. If name exists in both directories,
+// the one in the data directory is used and the path of the legacy one,
+// left in place, is returned.
+func (p *Params) MigrateLegacyData(name string) (string, error) {
+	if p != &MainNet {
+		return "", nil
+	}
+
+	legacy := filepath.Join(legacyDataDir, name)
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	target := p.DataPath(name)
+	if _, err := os.Stat(target); err == nil {
+		return legacy, nil
+	}
+
+	if err := os.MkdirAll(p.DataDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(legacy, target); err != nil {
+		return "", fmt.Errorf("moving %s to %s: %w", legacy, target, err)
+	}
+
+	return "", nil
+}
+
+// DefaultNode is the address of the node on the default port.
+func (p *Params) DefaultNode() string {
+	return "localhost:" + p.DefaultPort
+}
@@ -0,0 +1,16 @@
+package wallet
+
+// MultisigAddress is an address whose funds can only be spent with Required
+// signatures from the keys in PubKeys. Funds are locked to the hash of
+// RedeemScript, the script checking the signatures, which has to be
+// revealed when they are spent.
+type MultisigAddress struct {
+	Required     int
+	PubKeys      [][]byte
+	RedeemScript []byte
+}
+
+// Address returns the script hash address of the multisig.
+func (m MultisigAddress) Address() []byte {
+	return EncodeAddress(ScriptHashVersion(), ScriptHash(m.RedeemScript))
+}
@@ -5,14 +5,29 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"io"
+
+	"github.com/aadejanovs/blockchain-demo/params"
+	"github.com/mr-tron/base58"
 )
 
 const (
 	checksumLength = 4
-	version        = byte(0x00)
 )
 
+// PubKeyHashVersion prefixes addresses paying to the hash of a public key
+// on the selected network.
+func PubKeyHashVersion() byte {
+	return params.Active().PubKeyHashVersion
+}
+
+// ScriptHashVersion prefixes addresses paying to the hash of a script, like
+// multisig addresses, on the selected network.
+func ScriptHashVersion() byte {
+	return params.Active().ScriptHashVersion
+}
+
 type Wallet struct {
 	PrivateKey ed25519.PrivateKey
 }
@@ -26,7 +41,14 @@ func MakeWallet() *Wallet {
 
 func (w Wallet) Address() []byte {
 	pubHash := PublicKeyHash([]byte(w.PrivateKey.Public().(ed25519.PublicKey)))
-	versionedHash := append([]byte{version}, pubHash...)
+
+	return EncodeAddress(PubKeyHashVersion(), pubHash)
+}
+
+// EncodeAddress returns the address of hash with the version byte telling
+// what kind of hash it is.
+func EncodeAddress(version byte, hash []byte) []byte {
+	versionedHash := append([]byte{version}, hash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
@@ -35,14 +57,33 @@ func (w Wallet) Address() []byte {
 	return address
 }
 
+// DecodeAddress returns the version byte and hash of address.
+func DecodeAddress(address string) (byte, []byte, error) {
+	decoded, err := base58.Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(decoded) <= checksumLength || !ValidateAddress(address) {
+		return 0, nil, fmt.Errorf("address %s is not valid", address)
+	}
+
+	return decoded[0], decoded[1 : len(decoded)-checksumLength], nil
+}
+
 func (w Wallet) PublicKeyBytes() []byte {
 	return []byte(w.PrivateKey.Public().(ed25519.PublicKey))
 }
 
+// ValidateAddress reports whether address has a valid checksum and
+// belongs to the selected network.
 func ValidateAddress(address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
+	if version != PubKeyHashVersion() && version != ScriptHashVersion() {
+		return false
+	}
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
 
@@ -64,6 +105,12 @@ func PublicKeyHash(pubKey []byte) []byte {
 	return hash[:]
 }
 
+// ScriptHash returns the hash script hash addresses commit to.
+func ScriptHash(script []byte) []byte {
+	hash := sha256.Sum256(script)
+	return hash[:]
+}
+
 func Checksum(payload []byte) []byte {
 	firstHash := sha256.Sum256(payload)
 	secondHash := sha256.Sum256(firstHash[:])
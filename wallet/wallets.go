@@ -5,21 +5,26 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+
+	"github.com/aadejanovs/blockchain-demo/params"
 )
 
-const walletFile = "./tmp/wallets_%s.data"
+const walletFile = "wallets_%s.data"
 
 type PersistedWallets struct {
 	Wallets map[string][]byte
 }
 
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets  map[string]*Wallet
+	Multisig map[string]*MultisigAddress `json:",omitempty"`
 }
 
 func Load(nodeId string) (*Wallets, error) {
 	wallets := Wallets{
-		Wallets: make(map[string]*Wallet),
+		Wallets:  make(map[string]*Wallet),
+		Multisig: make(map[string]*MultisigAddress),
 	}
 
 	err := wallets.LoadFile(nodeId)
@@ -35,6 +40,24 @@ func (ws *Wallets) AddWallet() string {
 	return address
 }
 
+// AddMultisig stores a multisig address so its funds can be spent later.
+func (ws *Wallets) AddMultisig(multisig MultisigAddress) string {
+	address := string(multisig.Address())
+	ws.Multisig[address] = &multisig
+
+	return address
+}
+
+// GetMultisig returns the multisig address stored under address.
+func (ws Wallets) GetMultisig(address string) (MultisigAddress, bool) {
+	multisig, ok := ws.Multisig[address]
+	if !ok {
+		return MultisigAddress{}, false
+	}
+
+	return *multisig, true
+}
+
 func (ws *Wallets) GetAllAddresses() []string {
 	var addresses []string
 	for address := range ws.Wallets {
@@ -48,8 +71,20 @@ func (ws Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
+// LoadFile reads the wallet file of a node. A mainnet wallet file older
+// builds left in the old data directory is moved to the data directory
+// first.
 func (ws *Wallets) LoadFile(nodeId string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := walletPath(nodeId)
+
+	legacyFile, err := params.Active().MigrateLegacyData(filepath.Base(walletFile))
+	if err != nil {
+		log.Panic(err)
+	}
+	if legacyFile != "" {
+		log.Printf("Warning: %s is left in place, %s is used instead", legacyFile, walletFile)
+	}
+
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -67,21 +102,39 @@ func (ws *Wallets) LoadFile(nodeId string) error {
 		return err
 	}
 
-	ws.Wallets = persistedWallets.Wallets
+	// Addresses are keyed by their current encoding, so that wallets saved
+	// with other address version bytes keep working.
+	ws.Wallets = make(map[string]*Wallet, len(persistedWallets.Wallets))
+	for _, wallet := range persistedWallets.Wallets {
+		ws.Wallets[string(wallet.Address())] = wallet
+	}
+	for _, multisig := range persistedWallets.Multisig {
+		ws.Multisig[string(multisig.Address())] = multisig
+	}
 
 	return nil
 }
 
 func (ws *Wallets) SaveFile(nodeId string) {
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := walletPath(nodeId)
 
 	b, err := json.Marshal(ws)
 	if err != nil {
 		log.Panic(err)
 	}
 
+	err = os.MkdirAll(filepath.Dir(walletFile), 0755)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	err = os.WriteFile(walletFile, b, 0644)
 	if err != nil {
 		log.Panic(err)
 	}
 }
+
+// walletPath returns the wallet file of a node on the selected network.
+func walletPath(nodeId string) string {
+	return params.Active().DataPath(fmt.Sprintf(walletFile, nodeId))
+}